@@ -9,7 +9,11 @@
 //
 package main
 
+import "os"
+import "strings"
 import "testing"
+import "encoding/csv"
+import "path/filepath"
 
 import "certscan/certumich"
 import "certscan/util"
@@ -22,8 +26,13 @@ func Testdb(t *testing.T) {
 	const user = "certscan"
 	const pass = "aaaa"
 	const database = "certscan"
-	var db certumich.Certdb                 // working database
-	err := db.Connect(user, pass, database) // open
+	dbcon, err := certumich.Mysqlspec(user, pass, database).Open() // open
+	if err != nil {
+		t.Logf(err.Error())
+		t.FailNow()
+	}
+	var db certumich.Certdb // working database
+	err = db.Connect(dbcon, false)
 	if err != nil {
 		t.Logf(err.Error())
 		t.FailNow()
@@ -38,7 +47,7 @@ func Testdb(t *testing.T) {
 func TestTLDinfo(t *testing.T) {
 	const domainsuffixfile = "/home/john/projects/gocode/src/certscan/data/effective_tld_names.dat" // should be overrideable
 	var TLDinfo util.DomainSuffixes                                                                 // top-level domain info
-	err := TLDinfo.Loadpublicsuffixlist(domainsuffixfile)                                           // load list
+	err := TLDinfo.Loadpublicsuffixlist(domainsuffixfile, false)                                    // load list
 	if err != nil {
 		t.Logf(err.Error())
 		t.FailNow()
@@ -52,10 +61,48 @@ func TestTLDinfo(t *testing.T) {
 func TestCAinfo(t *testing.T) {
 	const caoidfile = "/home/john/projects/gocode/src/certscan/data/catypetable.csv" // should be overrideable
 	var CAinfo util.CApolicyinfo                                                     // policy info
-	err := CAinfo.Loadoidinfo(caoidfile)                                             // load list
+	_, err := CAinfo.Loadoidinfo(caoidfile, false)                                   // load list
 	if err != nil {
 		t.Logf(err.Error())
 		t.FailNow()
 	}
 	CAinfo.Dump()
 }
+
+//
+//  TestReadprovenance -- file, record number and byte offset of each record read
+//
+func TestReadprovenance(t *testing.T) {
+	record := func(first string) string { // one record, first field given, rest empty
+		return first + strings.Repeat(",", certumich.Fieldcount-1) + "\n"
+	}
+	rec1 := record(`"1"`)
+	rec2 := record(`"two` + "\n" + `lines"`) // quoted field with a line break
+	rec3 := record("3")
+	infile := filepath.Join(t.TempDir(), "certs.csv")
+	if err := os.WriteFile(infile, []byte(rec1+rec2+rec3), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var provs []certumich.Provenance
+	collect := func(fields []string, prov certumich.Provenance, outf *csv.Writer, outdb certumich.CertSink) error {
+		provs = append(provs, prov)
+		return nil
+	}
+	bad, err := readinputfile(infile, collect, nil, nil)
+	if err != nil || bad != 0 {
+		t.Fatalf("readinputfile: %d bad lines, %v", bad, err)
+	}
+	expected := []certumich.Provenance{
+		{Source_file: infile, Record_number: 1, Byte_offset: 0},
+		{Source_file: infile, Record_number: 2, Byte_offset: int64(len(rec1))},
+		{Source_file: infile, Record_number: 3, Byte_offset: int64(len(rec1) + len(rec2))},
+	}
+	if len(provs) != len(expected) {
+		t.Fatalf("readinputfile: got %d records, expected %d", len(provs), len(expected))
+	}
+	for i := range expected {
+		if provs[i] != expected[i] {
+			t.Errorf("record %d: got %+v, expected %+v", i+1, provs[i], expected[i])
+		}
+	}
+}
@@ -28,31 +28,137 @@ const RECMAX = 10000 // after this many, load the database
 //  Certdb -- database access object
 //
 type Certdb struct {
-	dbcon   *sql.DB
-	cloader util.SQLdataloader
-	dloader util.SQLdataloader
-	ploader util.SQLdataloader
+	Provenance bool   // load source file, record number and byte offset into certs
+	Scheme     string // "mysql" if empty, or "sqlite"
+	dbcon      *sql.DB
+	verbose    bool
+	cloader    util.Tableloader
+	dloader    util.Tableloader
+	ploader    util.Tableloader
+	iloader    util.Tableloader
+	hloader    util.Tableloader
+	eloader    util.Tableloader
+	issuers    map[string]Issuerinfo // issuer fields of first kept cert for each Issuer_id
+	cacerts    map[string]bool       // Certificate_ids of all CA certs seen in input. Set.
+	cakeys     map[string]bool       // subject key IDs of all CA certs seen in input. Set.
 }
 
 //
-//  Parameters for LOAD DATA INFILE LOCAL for the three tables
+//  Parameters for LOAD DATA INFILE LOCAL for the tables
 //
 var CLOADPARAMS = "INTO TABLE certs"
 var DLOADPARAMS = "INTO TABLE domains"
 var PLOADPARAMS = "INTO TABLE policies"
+var ILOADPARAMS = "INTO TABLE issuers"
+var HLOADPARAMS = "INTO TABLE hostnames"
+var ELOADPARAMS = "INTO TABLE evfindings"
 
 //
 //  Connect -- use database connection
 //
 func (d *Certdb) Connect(db *sql.DB, verbose bool) error {
 	d.dbcon = db
-	//  Prepare the three database table loaders - certs, domains, and policies.
+	d.verbose = verbose
+	//  Prepare the database table loaders - certs, domains, policies, issuers, hostnames, and evfindings.
+	d.cloader, d.dloader, d.ploader = d.newloader(), d.newloader(), d.newloader()
+	d.iloader, d.hloader, d.eloader = d.newloader(), d.newloader(), d.newloader()
 	d.cloader.Open(CLOADPARAMS, d.dbcon, RECMAX, verbose)
 	d.dloader.Open(DLOADPARAMS, d.dbcon, RECMAX, verbose)
 	d.ploader.Open(PLOADPARAMS, d.dbcon, RECMAX, verbose)
+	d.iloader.Open(ILOADPARAMS, d.dbcon, RECMAX, verbose)
+	d.hloader.Open(HLOADPARAMS, d.dbcon, RECMAX, verbose)
+	d.eloader.Open(ELOADPARAMS, d.dbcon, RECMAX, verbose)
+	d.issuers = make(map[string]Issuerinfo)
+	d.cacerts = make(map[string]bool)
+	d.cakeys = make(map[string]bool)
 	return nil
 }
 
+//
+//  newloader -- table loader for the database, not yet opened
+//
+func (d *Certdb) newloader() util.Tableloader {
+	if d.Scheme == "sqlite" { // no LOAD DATA INFILE
+		return &util.SQLiteloader{}
+	}
+	return &util.SQLdataloader{}
+}
+
+//
+//  Noterun -- record this run, with the suffix list used, in the runs table
+//
+//  Returns the suffix lists of earlier runs which differ from this one,
+//  if any. 2LDs from runs with different lists are not comparable, and
+//  a database loaded with lists A, B, B still mixes A and B; caller warns.
+//  Runs before the last complete rederive are not counted.
+//
+func (d *Certdb) Noterun(info util.Suffixlistinfo) ([]util.Suffixlistinfo, error) {
+	rows, err := d.dbcon.Query("SELECT MAX(Psl_file), MAX(Psl_version), MAX(Psl_commit), Psl_hash, Psl_private FROM runs " +
+		"WHERE Run_id >= (SELECT COALESCE(MAX(Run_id), 0) FROM runs WHERE Run_kind = 'REDERIVE') " +
+		"GROUP BY Psl_hash, Psl_private ORDER BY MAX(Run_id)")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var others []util.Suffixlistinfo
+	for rows.Next() {
+		var p util.Suffixlistinfo
+		var version, commit sql.NullString
+		err = rows.Scan(&p.File, &version, &commit, &p.Hash, &p.Private)
+		if err != nil {
+			return nil, err
+		}
+		if p.Hash == info.Hash && p.Private == info.Private { // same list as this run
+			continue
+		}
+		p.Version = version.String
+		p.Commit = commit.String
+		others = append(others, p)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	_, err = d.dbcon.Exec("INSERT INTO runs (Run_time, Psl_file, Psl_version, Psl_commit, Psl_hash, Psl_private) VALUES (CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)",
+		info.File, sqlnull(info.Version), sqlnull(info.Commit), info.Hash, info.Private)
+	if err != nil {
+		return nil, err
+	}
+	return others, nil
+}
+
+//
+//  Noterederive -- record a complete rederive, after which every row matches info
+//
+//  Only for a rederive which left no cert behind; see Rederivereport.Complete.
+//
+func (d *Certdb) Noterederive(info util.Suffixlistinfo) error {
+	_, err := d.dbcon.Exec("INSERT INTO runs (Run_time, Psl_file, Psl_version, Psl_commit, Psl_hash, Psl_private, Run_kind) VALUES (CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, 'REDERIVE')",
+		info.File, sqlnull(info.Version), sqlnull(info.Commit), info.Hash, info.Private)
+	return err
+}
+
+//
+//  InsertOIDs -- store CA policy OIDs in capolicies, for CertSink
+//
+func (d *Certdb) InsertOIDs(oidinfo *util.CApolicyinfo) error {
+	return util.InsertOIDs(d.dbcon, d.newloader(), oidinfo, d.verbose)
+}
+
+//
+//  ReadOIDs -- CA policy OIDs in capolicies, for Oidreader
+//
+func (d *Certdb) ReadOIDs() ([]util.Policyentry, error) {
+	return util.Readoiddb(d.dbcon)
+}
+
+//
+//  Close -- finish database update, for CertSink
+//
+func (d *Certdb) Close() error {
+	return d.Disconnect()
+}
+
 //
 //  Disconnect -- done with DB connection
 //
@@ -61,6 +167,9 @@ func (d *Certdb) Disconnect() error {
 		_ = d.cloader.Close()
 		_ = d.dloader.Close()
 		_ = d.ploader.Close()
+		_ = d.iloader.Close()
+		_ = d.hloader.Close()
+		_ = d.eloader.Close()
 	}()
 	//  Finish all files, with final write, flush, and database load
 	err := d.cloader.Close()
@@ -75,6 +184,22 @@ func (d *Certdb) Disconnect() error {
 	if err != nil {
 		return err
 	}
+	err = d.hloader.Close()
+	if err != nil {
+		return err
+	}
+	err = d.eloader.Close()
+	if err != nil {
+		return err
+	}
+	err = d.writeissuers() // issuers are only known at the end
+	if err != nil {
+		return err
+	}
+	err = d.iloader.Close()
+	if err != nil {
+		return err
+	}
 	//  Ought to commit here, but LOAD DATA INFILE implies commit
 	return nil
 }
@@ -82,12 +207,14 @@ func (d *Certdb) Disconnect() error {
 //
 //  Insertcert -- insert cert record
 //
-//  This requires updates to three tables.
+//  This requires updates to four tables. Issuers are written at Disconnect.
 //
 func (d *Certdb) Insertcert(c *Processedcert) error {
-	cline := c.PackcertforSQL()
+	cline := c.PackcertforSQL(d.Provenance)
 	dlines := c.PackdomainsforSQL()
 	plines := c.PackpoliciesforSQL()
+	hlines := c.PackhostnamesforSQL()
+	elines := c.PackevfindingsforSQL()
 	//  Write cert, domain, and policy load files
 	err := d.cloader.Write(cline) // single line
 	if err != nil {
@@ -101,5 +228,54 @@ func (d *Certdb) Insertcert(c *Processedcert) error {
 	if err != nil {
 		return err
 	}
+	err = d.hloader.Write(strings.Join(hlines, "")) // multiple lines
+	if err != nil {
+		return err
+	}
+	err = d.eloader.Write(strings.Join(elines, "")) // multiple lines, usually none
+	if err != nil {
+		return err
+	}
+	if _, ok := d.issuers[c.Issuer_id]; !ok && c.Issuer_id != "" { // first cert from this issuer
+		d.issuers[c.Issuer_id] = c.Issuerinfo()
+	}
+	return nil
+}
+
+//
+//  Notecert -- note a cert from the input, whether kept or not
+//
+//  Used to decide whether the issuer of a kept cert is itself in the dataset.
+//
+func (d *Certdb) Notecert(c *Processedcert) {
+	if !strings.HasPrefix(strings.ToLower(c.Is_ca), "t") { // only CA certs can be issuers
+		return
+	}
+	d.cacerts[c.Certificate_id] = true
+	keyid := strings.ToUpper(strings.TrimSpace(c.X_509_subjectKeyIdentifier))
+	if keyid != "" {
+		d.cakeys[keyid] = true
+	}
+}
+
+//
+//  issuerindataset -- true if the issuer's own cert was noted by Notecert
+//
+//  Matched by Certificate_id, or failing that by authority key ID.
+//
+func (d *Certdb) issuerindataset(issuer Issuerinfo) bool {
+	return d.cacerts[issuer.Issuer_id] || (issuer.Authority_key_id != "" && d.cakeys[issuer.Authority_key_id])
+}
+
+//
+//  writeissuers -- write one issuers line for each Issuer_id seen
+//
+func (d *Certdb) writeissuers() error {
+	for _, issuer := range d.issuers {
+		err := d.iloader.Write(issuer.PackissuerforSQL(d.issuerindataset(issuer)))
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -18,7 +18,6 @@ import "errors"
 import "certscan/util"
 import "fmt"
 import "time"
-import "code.google.com/p/go.net/idna"
 
 //
 //  Constants
@@ -77,34 +76,106 @@ type Rawcert struct {
 	Reason_revoked                   string
 }
 
+//
+//  Provenance -- where a raw cert record came from
+//
+type Provenance struct {
+	Source_file   string // input file name
+	Record_number int64  // CSV record number in file, from 1
+	Byte_offset   int64  // byte offset of start of record in file
+}
+
 //
 //  Processedcert -- raw cert plus some computed info
 //
 type Processedcert struct {
-	Rawcert                            // fields of the raw cert
-	Issuer_name              string    // name of CA issuing cert
-	Subject_commonname       string    // CN main domain, if any
-	Subject_commonname_2ld   string    // CN main domain, 2LD part only
-	Subject_organization     string    // O organization, if any
-	Subject_organizationunit string    // OU organization unit, if any
-	Subject_location         string    // L location, if any
-	Subject_countrycode      string    // CO countrycode, if any
-	Not_valid_before_time    time.Time // beginning of valid interval
-	Not_valid_after_time     time.Time // end of valid interval
-	Domains                  []string  // CN plus alt domains
-	Domains2ld               []string  // unique second level domains . tld
-	Policies                 []string  // policy OIDs
-	Valid                    bool      // true if valid
-	Is_browser_valid         bool      // at least one major browser vendor accepts this cert
-	CAsigned                 bool      // true if signed by CA, not self
-	Errors                   []string  // errors recorded
+	Rawcert                                       // fields of the raw cert
+	Provenance                                    // where the raw cert came from
+	Issuer_name              string               // name of CA issuing cert
+	Issuer_organization      string               // O of issuer, if any
+	Issuer_organizationunit  string               // OU of issuer, if any
+	Issuer_countrycode       string               // C of issuer, if any
+	Authority_key_id         string               // key ID of issuer's key, hex, if any
+	Subject_commonname       string               // CN main domain, if any
+	Subject_commonname_2ld   string               // CN main domain, 2LD part only
+	Subject_organization     string               // O organization, if any
+	Subject_organizationunit string               // OU organization unit, if any
+	Subject_location         string               // L location, if any
+	Subject_countrycode      string               // CO countrycode, if any
+	Subject_state            string               // ST state or province, if any
+	Not_valid_before_time    time.Time            // beginning of valid interval
+	Not_valid_after_time     time.Time            // end of valid interval
+	Domains                  []string             // CN plus alt domains
+	Domains2ld               []string             // unique second level domains . tld
+	Domains2ldsection        []util.Suffixsection // list section of TLD of each of Domains2ld
+	Hostnames                []Hostnameinfo       // unique names of Domains, each with its 2LD
+	Relatedness              float64              // relatedness of Domains2ld, 0 (unrelated) to 1
+	Relatednessscored        bool                 // Relatedness was set by caller; NULL in database if not
+	Inferred                 Levelguess           // validation level guessed from subject and issuer
+	Classified               util.Classification  // validation level from policy OIDs, set by caller
+	Evfindings               []Evfinding          // EV Guidelines subject problems, if Classified EV, set by caller
+	Policies                 []string             // policy OIDs
+	Policydetails            []Certpolicy         // policy OIDs with CPS URIs and user notices
+	Valid                    bool                 // true if valid
+	Is_browser_valid         bool                 // at least one major browser vendor accepts this cert
+	CAsigned                 bool                 // true if signed by CA, not self
+	Errors                   []string             // errors recorded
+	Problems                 []error              // errors recorded, as errors, in lenient mode
+	lenient                  bool                 // record problems and continue, rather than fail
+
+	//  EV Guidelines subject attributes
+	Subject_businesscategory     string // businessCategory, "Private Organization", etc.
+	Subject_serialnumber         string // serialNumber, registration number of organization
+	Subject_jurisdictioncountry  string // jurisdictionC, country of incorporation
+	Subject_jurisdictionstate    string // jurisdictionST, state of incorporation
+	Subject_jurisdictionlocality string // jurisdictionL, locality of incorporation
+}
+
+//
+//  Hostnameinfo -- one name from a cert, with its second level domain
+//
+//  Stored so 2LDs can be derived again when the suffix list changes.
+//
+type Hostnameinfo struct {
+	Name      string             // normalized, with "*." if wildcard
+	Domain2ld string             // "2ld.tld", or empty if not a domain
+	Section   util.Suffixsection // list section of TLD
+	Status    util.Hoststatus    // whether name is usable, and why not
+}
+
+//
+//  Parsemode -- what to do about a field which will not parse
+//
+type Parsemode int
+
+const (
+	Strict  Parsemode = iota // fail the whole certificate
+	Lenient                  // record the problem in Errors and keep going
+)
+
+//
+//  problem -- handle a parse problem according to the parse mode
+//
+//  In strict mode, returns err, and the caller gives up.
+//  In lenient mode, records err and returns nil, and the caller goes on
+//  with whatever it could parse.
+//
+func (c *Processedcert) problem(err error) error {
+	if !c.lenient {
+		return err
+	}
+	c.Errors = append(c.Errors, err.Error())
+	c.Problems = append(c.Problems, err)
+	return nil
 }
 
 //
 //  PackCertforSQL -- pack processed cert into fields for SQL LOAD DATA INFILE use
 //
-func (c *Processedcert) PackcertforSQL()(string) {
-    const Fieldcount = 26
+//  Provenance fields are loaded only if withprovenance.
+//
+func (c *Processedcert) PackcertforSQL(withprovenance bool)(string) {
+    const Fieldcount = 42
     var fields [Fieldcount]string
     fields[0] = util.ToSQLint(c.Certificate_id)
 	fields[1] = util.ToSQLint(c.Serial_number)              
@@ -133,6 +204,30 @@ func (c *Processedcert) PackcertforSQL()(string) {
     fields[23] = util.ToSQLstring(c.Subject_countrycode)
     fields[24] = util.ToSQLbool(strconv.FormatBool(c.Is_browser_valid))
     fields[25] = util.ToSQLstring(strings.Join(c.Errors,","))
+    fields[26] = "NONE"                                  // provenance, if wanted
+    fields[27] = "NONE"
+    fields[28] = "NONE"
+    if withprovenance {
+        fields[26] = util.ToSQLstring(c.Source_file)
+        fields[27] = util.ToSQLint(strconv.FormatInt(c.Record_number, 10))
+        fields[28] = util.ToSQLint(strconv.FormatInt(c.Byte_offset, 10))
+    }
+    fields[29] = "NONE"                                   // not scored, as for records which failed to unpack
+    if c.Relatednessscored {
+        fields[29] = util.ToSQLstring(strconv.FormatFloat(c.Relatedness, 'f', 3, 64))
+    }
+    fields[30] = util.ToSQLstring(c.Inferred.Level)
+    fields[31] = util.ToSQLstring(strconv.FormatFloat(c.Inferred.Confidence, 'f', 2, 64))
+    fields[32] = util.ToSQLstring(c.Inferred.Reason)
+    fields[33] = util.ToSQLstring(c.Classified.Level)
+    fields[34] = util.ToSQLstring(strings.Join(c.Classified.OIDs, " "))
+    fields[35] = util.ToSQLstring(strings.Join(c.Classified.Anomalies, "; "))
+    fields[36] = util.ToSQLstring(c.Subject_state)
+    fields[37] = util.ToSQLstring(c.Subject_businesscategory)
+    fields[38] = util.ToSQLstring(c.Subject_serialnumber)
+    fields[39] = util.ToSQLstring(c.Subject_jurisdictioncountry)
+    fields[40] = util.ToSQLstring(c.Subject_jurisdictionstate)
+    fields[41] = util.ToSQLstring(c.Subject_jurisdictionlocality)
     return util.ToSQLline(fields[:])    // return escaped fields for LOAD DATA INFILE
 }
 //
@@ -141,22 +236,79 @@ func (c *Processedcert) PackcertforSQL()(string) {
 func (c *Processedcert) PackdomainsforSQL()([]string) {
     lines := make([]string,0)                                // group of lines
     for i := range c.Domains2ld {                           // one line for each domain  
-        var fields [2]string
+        var fields [3]string
         fields[0] = util.ToSQLint(c.Certificate_id)
         fields[1] = util.ToSQLstring(c.Domains2ld[i])
+        fields[2] = util.ToSQLstring(c.Domains2ldsection[i].String())
         lines = append(lines,util.ToSQLline(fields[:]))    // return escaped fields for LOAD DATA INFILE
     }
     return lines
 }
+//
+//  PackhostnamesforSQL -- pack processed cert host names for SQL LOAD DATA INFILE use
+//
+func (c *Processedcert) PackhostnamesforSQL() []string {
+	lines := make([]string, 0, len(c.Hostnames)) // one line for each name
+	for i := range c.Hostnames {
+		var fields [5]string
+		fields[0] = util.ToSQLint(c.Certificate_id)
+		fields[1] = util.ToSQLstring(c.Hostnames[i].Name)
+		fields[2] = util.ToSQLstring(c.Hostnames[i].Domain2ld)
+		fields[3] = util.ToSQLstring(c.Hostnames[i].Section.String())
+		fields[4] = util.ToSQLstring(c.Hostnames[i].Status.String())
+		lines = append(lines, util.ToSQLline(fields[:]))
+	}
+	return lines
+}
+
+//
+//  Issuerinfo -- issuer fields of a cert, all that an issuers row needs
+//
+type Issuerinfo struct {
+	Issuer_id               string // Certificate_id of issuer cert
+	Issuer_name             string // CN of issuer
+	Issuer_organization     string // O of issuer
+	Issuer_organizationunit string // OU of issuer
+	Issuer_countrycode      string // C of issuer
+	Authority_key_id        string // key ID of issuer's key, hex, if any
+}
+
+//
+//  Issuerinfo -- issuer fields of processed cert
+//
+func (c *Processedcert) Issuerinfo() Issuerinfo {
+	return Issuerinfo{Issuer_id: c.Issuer_id, Issuer_name: c.Issuer_name, Issuer_organization: c.Issuer_organization,
+		Issuer_organizationunit: c.Issuer_organizationunit, Issuer_countrycode: c.Issuer_countrycode, Authority_key_id: c.Authority_key_id}
+}
+
+//
+//  PackissuerforSQL -- pack issuer fields for SQL LOAD DATA INFILE use
+//
+//  indataset is true if the issuer's own certificate was seen in the input.
+//
+func (i *Issuerinfo) PackissuerforSQL(indataset bool) string {
+	var fields [7]string
+	fields[0] = util.ToSQLint(i.Issuer_id)
+	fields[1] = util.ToSQLstring(i.Issuer_name)
+	fields[2] = util.ToSQLstring(i.Issuer_organization)
+	fields[3] = util.ToSQLstring(i.Issuer_organizationunit)
+	fields[4] = util.ToSQLstring(i.Issuer_countrycode)
+	fields[5] = util.ToSQLstring(i.Authority_key_id)
+	fields[6] = util.ToSQLbool(strconv.FormatBool(indataset))
+	return util.ToSQLline(fields[:]) // return escaped fields for LOAD DATA INFILE
+}
+
 //
 //  PackpoliciesforSQL -- pack processed cert domain fields for SQL LOAD DATA INFILE use
 //
 func (c *Processedcert) PackpoliciesforSQL()([]string) {
     lines := make([]string,0)                                // group of lines
-    for i := range c.Policies {                           // one line for each domain  
-        var fields [2]string
+    for i := range c.Policydetails {                      // one line for each policy
+        var fields [4]string
         fields[0] = util.ToSQLint(c.Certificate_id)
-        fields[1] = util.ToSQLstring(c.Policies[i])
+        fields[1] = util.ToSQLstring(c.Policydetails[i].OID)
+        fields[2] = util.ToSQLstring(strings.Join(c.Policydetails[i].CPS, " "))
+        fields[3] = util.ToSQLstring(c.Policydetails[i].Usernotice)
         lines = append(lines,util.ToSQLline(fields[:]))    // return escaped fields for LOAD DATA INFILE
     }
     return lines
@@ -176,7 +328,7 @@ type NameValue struct {
 //
 func (r *Rawcert) Unpackrawcert(s []string) error {
 	if len(s) < Fieldcount {
-		return errors.New("Not enough fields in certificate record")
+		return newparseerror(ErrBadRecord, "record", -1, fmt.Sprintf("%d fields", len(s)), nil)
 	}
 	r.Certificate_id = s[0]
 	r.Hex_encoded_SHA_1_fingerprint = s[1]
@@ -229,6 +381,8 @@ func (r *Rawcert) Unpackrawcert(s []string) error {
 //  Unpackaltdomains  -- unpack alt names field
 //
 //  Returns domains ("DNS") only.  No emails, etc.
+//  Pairs which do not parse are skipped; the domains from the others
+//  are returned along with an error for the first bad pair.
 //
 func (cfields *Rawcert) Unpackaltdomains() ([]string, error) {
 	subjectaltnames := strings.TrimSpace(cfields.X_509_subjectAltName) // get subject alt name field
@@ -239,12 +393,16 @@ func (cfields *Rawcert) Unpackaltdomains() ([]string, error) {
 	//  Have data of form "type:value, type:value"
 	pairs := strings.Split(subjectaltnames, ",") // split into tuples
 	domains := make([]string, 0, 10)             // make space for an array of strings
+	var err error                                // first bad pair, if any
 	for i := range pairs {                       // iterate over pairs
 		pair := pairs[i]                          // this pair
 		typevalue := strings.SplitN(pair, ":", 2) // split at first ":" (IPv6 addresses have ":" in them)
 		if len(typevalue) != 2 {                  // should always be 2
-			var empty []string // empty array of strings
-			return empty, errors.New("Unexpected text in alt domain field: '" + subjectaltnames + "'")
+			if err == nil {
+				err = newparseerror(ErrBadSAN, "X_509_subjectAltName", Colsubjectaltname, subjectaltnames,
+					errors.New("Unexpected text in alt domain field"))
+			}
+			continue
 		}
 		typepart := strings.TrimSpace(typevalue[0])
 		domain := strings.TrimSpace(typevalue[1])
@@ -252,7 +410,7 @@ func (cfields *Rawcert) Unpackaltdomains() ([]string, error) {
 			domains = append(domains, domain) // add to
 		}
 	}
-	return domains, nil // normal return
+	return domains, err // normal return
 }
 
 type KeyValueMap map[string]string // a key/value map
@@ -299,17 +457,87 @@ func Unpackparamfields(s string) (KeyValueMap, error) {
 }
 
 //
-//  Unpackcertpolicies  -- extract cert policy OIDs for later use
+//  Certpolicy -- one policy OID and its qualifiers
+//
+type Certpolicy struct {
+	OID        string   // policy OID
+	CPS        []string // CPS URIs, if any
+	Usernotice string   // user notice explicit text, if any
+}
+
+//
+//  Unpackcertpolicies  -- extract cert policy OIDs and their qualifiers for later use
+//
+//  Field is in OpenSSL text form:
+//
+//    Policy: 2.16.840.1.114412.1.1
+//      CPS: https://www.digicert.com/CPS
+//      User Notice:
+//        Explicit Text: Any use of this Certificate constitutes acceptance...
+//
+//  Line breaks are not significant; we work from whitespace-separated tokens.
 //
 func (c *Processedcert) Unpackcertpolicies() error {
+	const (
+		qualnone = iota // not in a qualifier
+		qualcps         // next token is a CPS URI
+		qualtext        // collecting user notice text
+	)
 	policyfields := strings.Fields(c.X_509_certificatePolicies) // contains policy OIDS, plus other messages
 	c.Policies = make([]string, 0, 1)                           // seldom more than 1 OID
-	for i := range policyfields {                               // for all fields
+	c.Policydetails = make([]Certpolicy, 0, 1)
+	cur := -1              // index of policy being qualified, if any
+	var text []string      // words of user notice text
+	mode := qualnone       // what the next token is
+	finishtext := func() { // attach any collected notice text to current policy
+		if cur >= 0 && len(text) > 0 {
+			notice := &c.Policydetails[cur].Usernotice
+			if *notice != "" {
+				*notice += " "
+			}
+			*notice += strings.Join(text, " ")
+		}
+		text = text[:0]
+	}
+	for i := 0; i < len(policyfields); i++ { // for all fields
 		field := policyfields[i]
-		if util.IsOID(field) { // if it looks like an OID
+		next := ""
+		if i+1 < len(policyfields) {
+			next = policyfields[i+1]
+		}
+		switch {
+		case field == "Policy:": // just introduces an OID
+			finishtext()
+			mode = qualnone
+		case util.IsOID(field) && mode != qualtext: // if it looks like an OID
+			finishtext()
 			c.Policies = append(c.Policies, field) // append to OIDs
+			c.Policydetails = append(c.Policydetails, Certpolicy{OID: field})
+			cur = len(c.Policydetails) - 1
+			mode = qualnone
+		case field == "CPS:":
+			finishtext()
+			mode = qualcps
+		case field == "User" && next == "Notice:":
+			finishtext()
+			mode = qualnone
+			i++ // skip "Notice:"
+		case field == "Explicit" && next == "Text:":
+			finishtext()
+			mode = qualtext
+			i++ // skip "Text:"
+		case field == "Organization:" || field == "Number:" || field == "Numbers:": // notice reference, not kept
+			finishtext()
+			mode = qualnone
+		case cur < 0: // text before any OID, ignore
+		case mode == qualcps:
+			c.Policydetails[cur].CPS = append(c.Policydetails[cur].CPS, field)
+			mode = qualnone
+		case mode == qualtext:
+			text = append(text, field)
 		}
 	}
+	finishtext()
 	return nil
 }
 
@@ -319,69 +547,184 @@ func (c *Processedcert) Unpackcertpolicies() error {
 func (c *Processedcert) Unpackissuer() error {
 	issuerparams, err := Unpackparamfields(c.Issuer) // unpack Subject field
 	if err != nil {
-		return err // pass error upward
+		err = c.problem(newparseerror(ErrBadDN, "Issuer", Colissuer, c.Issuer, err))
+		if err != nil {
+			return err // pass error upward
+		}
 	}
 	c.Issuer_name = issuerparams["CN"] // common name of issuer
+	c.Issuer_organization = issuerparams["O"]
+	c.Issuer_organizationunit = issuerparams["OU"]
+	c.Issuer_countrycode = issuerparams["C"]
+	c.Authority_key_id = Unpackkeyid(c.X_509_authorityKeyIdentifier)
 	return nil
 }
 
+//
+//  Unpackkeyid -- get key ID from authority key identifier field
+//
+//  Field is of the form "keyid:AB:CD:...", possibly followed by
+//  "DirName:..." and "serial:..." items, which we don't keep.
+//  Returns upper case hex with colons, or "" if no key ID.
+//
+func Unpackkeyid(s string) string {
+	const keyidprefix = "keyid:"
+	items := strings.Fields(s)
+	for i := range items {
+		if strings.HasPrefix(items[i], keyidprefix) {
+			return strings.ToUpper(strings.TrimPrefix(items[i], keyidprefix))
+		}
+	}
+	return ""
+}
+
 //
 //  Unpacksubject  -- unpack subject field
 //
 //  Finds any second level domains
 //
 func (c *Processedcert) Unpacksubject(TLDinfo util.DomainSuffixes) error {
-	c.Domains = make([]string, 0, 2)                   // result domains
-	c.Domains2ld = make([]string, 0, 2)                // result second level domains
+	c.Domains = make([]string, 0, 2)    // result domains
+	c.Domains2ld = make([]string, 0, 2) // result second level domains
+	c.Domains2ldsection = make([]util.Suffixsection, 0, 2)
+	c.Hostnames = make([]Hostnameinfo, 0, 2)
 	subjectparams, err := Unpackparamfields(c.Subject) // unpack Subject field
 	if err != nil {
-		return err // pass error upward
-	}
-	c.Subject_commonname, err = idna.ToUnicode(subjectparams["CN"])  // Common Name, i.e. main domain
-	if err != nil {                                     // bad punycode
-		return err // pass error upward
+		err = c.problem(newparseerror(ErrBadDN, "Subject", Colsubject, c.Subject, err))
+		if err != nil {
+			return err // pass error upward
+		}
 	}
-	_, c2nd, ctld, cok := TLDinfo.Domainparts(c.Subject_commonname) // break apart CN domain field
-	if cok {                                            // if valid second level domain
-	    c.Subject_commonname_2ld = c2nd + "." + ctld   // get second level domain.tld only.
+	cn, cnclass, err := util.NormalizeHostname(subjectparams["CN"]) // Common Name, i.e. main domain
+	cnbadidn := err != nil
+	if err != nil { // bad punycode
+		err = c.problem(newparseerror(ErrBadIDN, "Subject", Colsubject, subjectparams["CN"], err))
+		if err != nil {
+			return err // pass error upward
+		}
 	}
+	c.Subject_commonname = hostdisplayname(cn, cnclass)
+	//  CN may be a person's name, an IP, etc., with no 2LD
+	c.Subject_commonname_2ld, _, _ = hostname2ld(&TLDinfo, cn, cnclass)
 	c.Subject_organization = subjectparams["O"] // Organization
 	c.Subject_organizationunit = subjectparams["OU"]
 	c.Subject_location = subjectparams["L"]
 	c.Subject_countrycode = subjectparams["C"]
-	c.Domains, err = c.Unpackaltdomains() // unpack alt names into domains
+	c.Subject_state = subjectattr(subjectparams, "ST", "2.5.4.8")
+	c.Subject_businesscategory = subjectattr(subjectparams, "businessCategory", "2.5.4.15")
+	c.Subject_serialnumber = subjectattr(subjectparams, "serialNumber", "2.5.4.5")
+	c.Subject_jurisdictioncountry = subjectattr(subjectparams, "jurisdictionC", "jurisdictionCountryName", "1.3.6.1.4.1.311.60.2.1.3")
+	c.Subject_jurisdictionstate = subjectattr(subjectparams, "jurisdictionST", "jurisdictionStateOrProvinceName", "1.3.6.1.4.1.311.60.2.1.2")
+	c.Subject_jurisdictionlocality = subjectattr(subjectparams, "jurisdictionL", "jurisdictionLocalityName", "1.3.6.1.4.1.311.60.2.1.1")
+	altnames, err := c.Unpackaltdomains() // unpack alt names into domains
 	if err != nil {
-		return err // pass error upward
-	}
-	if c.Subject_commonname != "" {
-		c.Domains = append(c.Domains, c.Subject_commonname) // first domain if present
+		err = c.problem(err)
+		if err != nil {
+			return err // pass error upward
+		}
 	}
 	//  Now have list of domains.  See which ones are unique second level domains
-	map2tld := make(map[string]bool) // second level domains, set
-	for i := range c.Domains {       // for all domains
-	    domain, err := idna.ToUnicode(c.Domains[i])
-	    if err != nil {
-		    return err // pass error upward
-	    }
-		_, a2nd, atld, aok := TLDinfo.Domainparts(domain) // break apart domain
-		if !aok {                                               // skip any non-domain junk
-			continue
+	map2tld := make(map[string]util.Suffixsection) // second level domains, with list section
+	seen := make(map[string]bool)                  // names already in Hostnames
+	notename := func(h Hostnameinfo) {             // each name once, with why it was not used, if it was not
+		if !seen[h.Name] {
+			seen[h.Name] = true
+			c.Hostnames = append(c.Hostnames, h)
+		}
+	}
+	add2ld := func(name string, class util.Hostclass) {
+		display := hostdisplayname(name, class)
+		c.Domains = append(c.Domains, display)
+		domain2ld, section, status := hostname2ld(&TLDinfo, name, class)
+		if domain2ld != "" { // skip IPs and other non-domain junk
+			map2tld[domain2ld] = section // add to map
+		}
+		notename(Hostnameinfo{Name: display, Domain2ld: domain2ld, Section: section, Status: status})
+	}
+	for i := range altnames { // for all alt domains
+		name, class, err := util.NormalizeHostname(altnames[i])
+		if err != nil {
+			err = c.problem(newparseerror(ErrBadIDN, "X_509_subjectAltName", Colsubjectaltname, altnames[i], err))
+			if err != nil {
+				return err // pass error upward
+			}
+			notename(Hostnameinfo{Name: strings.ToLower(name), Status: util.Hostbadidn})
+			continue // skip this one
 		}
-		map2tld[a2nd+"."+atld] = true // add to map
+		add2ld(name, class)
+	}
+	switch {
+	case cn == "": // no CN
+	case cnbadidn:
+		c.Domains = append(c.Domains, cn)
+		notename(Hostnameinfo{Name: strings.ToLower(cn), Status: util.Hostbadidn})
+	default:
+		add2ld(cn, cnclass) // last domain if present
 	}
-	for k, _ := range map2tld { // map keys -> array of strings
+	for k, section := range map2tld { // map keys -> array of strings
 		c.Domains2ld = append(c.Domains2ld, k) // lambdas in Go would be nice but are not essential
+		c.Domains2ldsection = append(c.Domains2ldsection, section)
 	}
 	return nil // success
 }
 
+//
+//  hostname2ld -- second level domain of a normalized name, its list section, and its status
+//
+//  Returns "" if the name is not a valid domain, or has no known public suffix,
+//  with the status saying which.
+//
+func hostname2ld(TLDinfo *util.DomainSuffixes, name string, class util.Hostclass) (string, util.Suffixsection, util.Hoststatus) {
+	status := util.Validatehostname(name, class)
+	if !status.Isused() { // skip IPs and such
+		return "", util.Sectionnone, status
+	}
+	_, second, tld, section, ok := TLDinfo.Domaininfo(name) // break apart domain
+	if !ok {
+		return "", util.Sectionnone, util.Hostnosuffix
+	}
+	return second + "." + tld, section, status
+}
+
+//
+//  subjectattr -- first of the names of a Subject attribute present
+//
+//  OpenSSL prints attributes by short name, long name, or OID, depending on version.
+//
+func subjectattr(params KeyValueMap, names ...string) string {
+	for _, name := range names {
+		if v := params[name]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+//
+//  hostdisplayname -- normalized name as stored, with wildcard put back
+//
+func hostdisplayname(name string, class util.Hostclass) string {
+	if class == util.Wildcard {
+		return "*." + name
+	}
+	return name
+}
+
 //
 //  Unpackcert -- unpack cert into structure for further processing
 //
-func Unpackcert(s []string, tldinfo util.DomainSuffixes) (Processedcert, error) {
+//  Errors are *ParseError, with a kind usable with errors.Is.
+//
+//  In Strict mode, the first field which will not parse fails the cert.
+//  In Lenient mode, problems are recorded in Errors and Problems, and
+//  the cert has whatever fields could be parsed. Only a record with
+//  the wrong number of fields fails.
+//
+func Unpackcert(s []string, tldinfo util.DomainSuffixes, mode Parsemode) (Processedcert, error) {
 	const CERTTIME = "2006-01-02 15:04:05" // format of timestamp in SSL cert
 	var c Processedcert                    // cert after processing
-	err := c.Unpackrawcert(s)              // unpack basic fields as strings
+	c.lenient = mode == Lenient
+	err := c.Unpackrawcert(s) // unpack basic fields as strings
 	if err != nil {
 		return c, err
 	}
@@ -393,11 +736,17 @@ func Unpackcert(s []string, tldinfo util.DomainSuffixes) (Processedcert, error)
 	c.CAsigned = !strings.HasPrefix("t", c.Is_self_signed)                  // signed by CA, not self
 	c.Not_valid_before_time, err = time.Parse(CERTTIME, c.Not_valid_before) // date range for cert
 	if err != nil {
-		return c, err
+		err = c.problem(newparseerror(ErrBadDate, "Not_valid_before", Colnotvalidbefore, c.Not_valid_before, err))
+		if err != nil {
+			return c, err
+		}
 	}
 	c.Not_valid_after_time, err = time.Parse(CERTTIME, c.Not_valid_after)
 	if err != nil {
-		return c, err
+		err = c.problem(newparseerror(ErrBadDate, "Not_valid_after", Colnotvalidafter, c.Not_valid_after, err))
+		if err != nil {
+			return c, err
+		}
 	}
 
 	//  Unpack structured fields
@@ -413,7 +762,37 @@ func Unpackcert(s []string, tldinfo util.DomainSuffixes) (Processedcert, error)
 	if err != nil {
 		return c, err
 	}
-	return c, nil // success
+	c.Inferred = c.Inferlevel() // guess, for when policy OIDs are no help
+	return c, nil               // success
+}
+
+//
+//  Policyoids -- policy OIDs of cert, for util.Policycert
+//
+func (c *Processedcert) Policyoids() []string {
+	return c.Policies
+}
+
+//
+//  Policycps -- CPS URIs of a policy OID, for util.Policycert
+//
+func (c *Processedcert) Policycps(oid string) []string {
+	for _, p := range c.Policydetails {
+		if p.OID == oid {
+			return p.CPS
+		}
+	}
+	return nil
+}
+
+//
+//  Issuerorg -- O of issuer, or CN if no O, for util.Policycert
+//
+func (c *Processedcert) Issuerorg() string {
+	if c.Issuer_organization != "" {
+		return c.Issuer_organization
+	}
+	return c.Issuer_name
 }
 
 //
@@ -434,12 +813,44 @@ func Seterror(fields []string, msg string) {
 func (c *Processedcert) Dump() {
 	fmt.Printf("Cert CN: '%s'  Organization: '%s'  Location: %s (%s).  Issued by '%s'\n",
 		c.Subject_commonname, c.Subject_organization, c.Subject_location, c.Subject_countrycode, c.Issuer_name)
+	fmt.Printf("  Issuer O: '%s'  OU: '%s'  C: %s  Issuer id: %s  Authority key id: %s\n",
+		c.Issuer_organization, c.Issuer_organizationunit, c.Issuer_countrycode, c.Issuer_id, c.Authority_key_id)
+	if c.Source_file != "" {
+		fmt.Printf("  Source: '%s' record %d at byte offset %d.\n", c.Source_file, c.Record_number, c.Byte_offset)
+	}
 	fmt.Printf("  Valid from %s to %s.\n", c.Not_valid_before_time.Format(time.ANSIC), c.Not_valid_after_time.Format(time.ANSIC))
 	fmt.Printf("  Second level domains: ")
 	for i := range c.Domains2ld {
 		fmt.Printf(" '%s'", c.Domains2ld[i])
+		if c.Domains2ldsection[i] == util.Sectionprivate {
+			fmt.Printf(" (PRIVATE)")
+		}
 	}
 	fmt.Println("")
+	if c.Classified.Level != "" {
+		fmt.Printf("  Policy level %s from OIDs %s\n", c.Classified.Level, strings.Join(c.Classified.OIDs, " "))
+	}
+	for _, anomaly := range c.Classified.Anomalies {
+		fmt.Printf("  Policy anomaly: %s\n", anomaly)
+	}
+	if c.Subject_businesscategory != "" || c.Subject_jurisdictioncountry != "" {
+		fmt.Printf("  Business category: '%s'  Serial number: '%s'  Jurisdiction: %s/%s/%s\n", c.Subject_businesscategory,
+			c.Subject_serialnumber, c.Subject_jurisdictioncountry, c.Subject_jurisdictionstate, c.Subject_jurisdictionlocality)
+	}
+	for _, f := range c.Evfindings {
+		fmt.Printf("  EV finding: %s %s\n", f.Rule, f.Detail)
+	}
+	if c.Inferred.Level != "" {
+		fmt.Printf("  Inferred level %s (confidence %1.2f): %s\n", c.Inferred.Level, c.Inferred.Confidence, c.Inferred.Reason)
+	}
+	for i := range c.Policydetails {
+		pol := c.Policydetails[i]
+		owner := ""
+		if i < len(c.Classified.Owners) && c.Classified.Owners[i] != "" { // Owners goes with Policies, if Classified
+			owner = " (" + c.Classified.Owners[i] + ")"
+		}
+		fmt.Printf("  Policy %s%s  CPS: %s  Notice: '%s'\n", pol.OID, owner, strings.Join(pol.CPS, " "), pol.Usernotice)
+	}
 	util.Dumpstrstruct(c.Rawcert) // dump fields of raw CSV record
 	fmt.Printf("\n")
 
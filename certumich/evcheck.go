@@ -0,0 +1,129 @@
+//
+//  evcheck.go -- EV Guidelines subject checks for certs classified EV
+//
+//  An EV cert must say who the subject is: organization, business
+//  category, registration number, jurisdiction of incorporation, and
+//  place of business. Wildcard names are not allowed. A cert with an
+//  EV policy OID which lacks these is misissued, or the OID table is
+//  wrong about the OID.
+//
+package certumich
+
+import "strings"
+import "certscan/util"
+
+//
+//  Evrule -- which EV requirement a cert fails
+//
+type Evrule int
+
+const (
+	Evnoorganization Evrule = iota // no subject O
+	Evnobusinesscategory
+	Evbadbusinesscategory // not one of the four categories
+	Evnoserialnumber
+	Evnojurisdictioncountry
+	Evnocountry
+	Evnolocality // neither L nor ST
+	Evwildcard
+)
+
+//
+//  String -- for reports and the database
+//
+func (r Evrule) String() string {
+	switch r {
+	case Evnoorganization:
+		return "NOORGANIZATION"
+	case Evnobusinesscategory:
+		return "NOBUSINESSCATEGORY"
+	case Evbadbusinesscategory:
+		return "BADBUSINESSCATEGORY"
+	case Evnoserialnumber:
+		return "NOSERIALNUMBER"
+	case Evnojurisdictioncountry:
+		return "NOJURISDICTIONCOUNTRY"
+	case Evnocountry:
+		return "NOCOUNTRY"
+	case Evnolocality:
+		return "NOLOCALITY"
+	case Evwildcard:
+		return "WILDCARD"
+	}
+	return "UNKNOWN"
+}
+
+//
+//  Evfinding -- one EV requirement a cert fails
+//
+type Evfinding struct {
+	Rule   Evrule // which requirement
+	Detail string // offending value, if any
+}
+
+//
+//  Business categories allowed by the EV Guidelines.
+//
+var evbusinesscategories = map[string]bool{
+	"private organization":  true,
+	"government entity":     true,
+	"business entity":       true,
+	"non-commercial entity": true,
+}
+
+//
+//  Checkev -- check subject of a cert classified EV against the EV Guidelines
+//
+//  Returns nothing unless Classified says EV; Classified must be set first.
+//
+func (c *Processedcert) Checkev() []Evfinding {
+	if c.Classified.Level != "EV" {
+		return nil
+	}
+	var findings []Evfinding
+	note := func(rule Evrule, detail string) {
+		findings = append(findings, Evfinding{rule, detail})
+	}
+	if c.Subject_organization == "" {
+		note(Evnoorganization, "")
+	}
+	switch {
+	case c.Subject_businesscategory == "":
+		note(Evnobusinesscategory, "")
+	case !evbusinesscategories[strings.ToLower(strings.TrimSpace(c.Subject_businesscategory))]:
+		note(Evbadbusinesscategory, c.Subject_businesscategory)
+	}
+	if c.Subject_serialnumber == "" {
+		note(Evnoserialnumber, "")
+	}
+	if c.Subject_jurisdictioncountry == "" {
+		note(Evnojurisdictioncountry, "")
+	}
+	if c.Subject_countrycode == "" {
+		note(Evnocountry, "")
+	}
+	if c.Subject_location == "" && c.Subject_state == "" {
+		note(Evnolocality, "")
+	}
+	for _, name := range c.Domains {
+		if strings.HasPrefix(name, "*.") {
+			note(Evwildcard, name)
+		}
+	}
+	return findings
+}
+
+//
+//  PackevfindingsforSQL -- pack EV findings for SQL LOAD DATA INFILE use
+//
+func (c *Processedcert) PackevfindingsforSQL() []string {
+	lines := make([]string, 0, len(c.Evfindings)) // one line for each finding
+	for i := range c.Evfindings {
+		var fields [3]string
+		fields[0] = util.ToSQLint(c.Certificate_id)
+		fields[1] = util.ToSQLstring(c.Evfindings[i].Rule.String())
+		fields[2] = util.ToSQLstring(c.Evfindings[i].Detail)
+		lines = append(lines, util.ToSQLline(fields[:]))
+	}
+	return lines
+}
@@ -0,0 +1,106 @@
+//
+//  levelinfer.go -- guess validation level of certs without a known policy OID
+//
+//  Many CAs have no policy OIDs in the OID table, so their certs can't
+//  be classified by OID. This guesses DV, OV, IV or EV from what is in
+//  the cert, with a confidence and the reason. The guess is kept apart from
+//  the OID-derived level, which is better when there is one.
+//
+package certumich
+
+import "strings"
+
+//
+//  Levelguess -- inferred validation level
+//
+type Levelguess struct {
+	Level      string  // "DV", "OV", "IV", "EV", or "" if no guess
+	Confidence float64 // 0 to 1
+	Reason     string  // which rule decided
+}
+
+//
+//  Issuer name keywords, and the level they imply. IV, individual
+//  validated, is a level of its own in the CA/B Forum OIDs; only an
+//  issuer keyword says a cert is IV.
+//
+var issuerkeywords = []struct {
+	keyword string
+	level   string
+}{
+	{"extended validation", "EV"},
+	{" ev ", "EV"},
+	{"domain validation", "DV"},
+	{"domain validated", "DV"},
+	{" dv ", "DV"},
+	{"organization validation", "OV"},
+	{"organisation validation", "OV"},
+	{" ov ", "OV"},
+	{"individual validation", "IV"},
+	{"individual validated", "IV"},
+	{" iv ", "IV"},
+}
+
+//
+//  Issuer families which issue mostly one level, by issuer name or
+//  organization. Less certain than keywords.
+//
+var issuerfamilies = []struct {
+	family string
+	level  string
+}{
+	{"rapidssl", "DV"},
+	{"alphassl", "DV"},
+	{"ssl123", "DV"},
+	{"positivessl", "DV"},
+	{"essentialssl", "DV"},
+	{"startcom class 1", "DV"},
+	{"go daddy secure", "DV"},
+	{"starfield secure", "DV"},
+	{"geotrust dv", "DV"},
+	{"startcom class 2", "OV"},
+	{"high assurance", "OV"},
+	{"secure site pro", "OV"},
+}
+
+//
+//  Inferlevel -- guess validation level from subject and issuer
+//
+//  Rules, most certain first:
+//    EV-only subject attributes            EV  0.9
+//    issuer name keyword, "... EV SSL CA"  any 0.8  (DV, OV, IV or EV)
+//    issuer family default, "RapidSSL"     any 0.6  (DV or OV)
+//    subject O with L or C                 OV  0.5
+//    subject O only                        OV  0.4
+//    no subject O                          DV  0.5
+//  Self-signed certs have no validation, and get no guess.
+//
+func (c *Processedcert) Inferlevel() Levelguess {
+	if strings.HasPrefix(strings.ToLower(c.Is_self_signed), "t") {
+		return Levelguess{Reason: "self-signed"}
+	}
+	if c.Subject_businesscategory != "" && (c.Subject_jurisdictioncountry != "" ||
+		c.Subject_jurisdictionstate != "" || c.Subject_jurisdictionlocality != "") { // only EV certs have these
+		return Levelguess{"EV", 0.9, "EV subject attributes"}
+	}
+	issuer := " " + strings.ToLower(c.Issuer_name+" "+c.Issuer_organizationunit) + " "
+	issuer = strings.NewReplacer("-", " ", ",", " ", "(", " ", ")", " ").Replace(issuer)
+	for _, k := range issuerkeywords {
+		if strings.Contains(issuer, k.keyword) {
+			return Levelguess{k.level, 0.8, "issuer name has '" + strings.TrimSpace(k.keyword) + "'"}
+		}
+	}
+	issuer += strings.ToLower(c.Issuer_organization)
+	for _, f := range issuerfamilies {
+		if strings.Contains(issuer, f.family) {
+			return Levelguess{f.level, 0.6, "issuer family '" + f.family + "'"}
+		}
+	}
+	switch {
+	case c.Subject_organization != "" && (c.Subject_location != "" || c.Subject_countrycode != ""):
+		return Levelguess{"OV", 0.5, "subject organization and location"}
+	case c.Subject_organization != "":
+		return Levelguess{"OV", 0.4, "subject organization only"}
+	}
+	return Levelguess{"DV", 0.5, "no subject organization"}
+}
@@ -0,0 +1,88 @@
+//
+//  parseerror -- typed errors from unpacking certificate records
+//
+//  Each error says which column failed and why, so callers can
+//  count failures by cause and decide what to tolerate.
+//
+package certumich
+
+import "errors"
+import "fmt"
+
+//
+//  Kinds of parse failure. Use with errors.Is.
+//
+var (
+	ErrBadRecord = errors.New("bad record format")                 // wrong number of fields
+	ErrBadDate   = errors.New("bad date")                          // validity date not parseable
+	ErrBadDN     = errors.New("bad distinguished name")            // Subject or Issuer not NAME=value form
+	ErrBadIDN    = errors.New("bad internationalized domain name") // punycode conversion failed
+	ErrBadSAN    = errors.New("bad subject alternative name")      // alt name field not type:value form
+)
+
+//
+//  Column indices of fields in a U. Mich. record which can fail to parse.
+//
+const (
+	Colsubject        = 5
+	Colissuer         = 6
+	Colnotvalidbefore = 9
+	Colnotvalidafter  = 10
+	Colsubjectaltname = 25
+)
+
+//
+//  ParseError -- failure to unpack one field of a certificate record
+//
+type ParseError struct {
+	Kind   error  // one of the Err... kinds above
+	Field  string // name of field in Rawcert
+	Column int    // column index in input record, -1 if whole record
+	Value  string // raw value of field
+	Err    error  // underlying cause, if any
+}
+
+//
+//  newparseerror -- make a ParseError
+//
+func newparseerror(kind error, field string, column int, value string, cause error) *ParseError {
+	return &ParseError{Kind: kind, Field: field, Column: column, Value: value, Err: cause}
+}
+
+//
+//  Error -- error message
+//
+func (e *ParseError) Error() string {
+	msg := fmt.Sprintf("%s in %s (column %d): '%s'", e.Kind.Error(), e.Field, e.Column, e.Value)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+//
+//  Unwrap -- underlying cause, for errors.Is and errors.As
+//
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+//
+//  Is -- true if target is this error's kind
+//
+func (e *ParseError) Is(target error) bool {
+	return target == e.Kind
+}
+
+//
+//  Errorkind -- kind of a parse error, as a string for tallies
+//
+//  Returns "other" for errors which are not ParseErrors.
+//
+func Errorkind(err error) string {
+	var perr *ParseError
+	if errors.As(err, &perr) {
+		return perr.Kind.Error()
+	}
+	return "other"
+}
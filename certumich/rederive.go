@@ -0,0 +1,466 @@
+//
+//  rederive.go -- derive 2LDs in the database again with a new suffix list
+//
+//  The 2LD of a name depends on the public suffix list. When the list
+//  changes, the stored host names are run through the new list and
+//  hostnames, domains and certs are updated in place, instead of
+//  reloading the raw certificate dumps.
+//
+//  Certs loaded before the hostnames table existed have only their
+//  2LDs. Those are run through the new list instead, which handles a
+//  suffix dropped from the list but not a new suffix over a stored 2LD
+//  ("blogspot.com" becoming a suffix); such certs are counted and left
+//  as they were, and need reloading.
+//
+package certumich
+
+import "database/sql"
+import "sort"
+import "certscan/util"
+
+//
+//  Rederivechange -- host names which moved from one 2LD to another
+//
+type Rederivechange struct {
+	Old2ld    string // 2LD with previous list, "" if none
+	New2ld    string // 2LD with current list, "" if none
+	Hostnames int64  // number of host names moved
+	Example   string // first host name moved
+}
+
+//
+//  Rederivereport -- what Rederive did
+//
+type Rederivereport struct {
+	Hostnames          int64            // host names examined
+	Hostnameschanged   int64            // host names with a new 2LD, list section or status
+	Certschanged       int64            // certs whose domains rows were replaced
+	Certsnohostnames   int64            // certs with no stored host names, re-derived from their 2LDs
+	Certsunrederivable int64            // of those, certs with a 2LD which is now a suffix, left as they were
+	Commonnames        int64            // certs with a new Subject_commonname_2ld
+	Changes            []Rederivechange // by old and new 2LD, most host names first
+}
+
+//
+//  Complete -- true if every cert now matches the new list
+//
+func (r *Rederivereport) Complete() bool {
+	return r.Certsunrederivable == 0
+}
+
+//
+//  sqlnull -- empty string as SQL NULL
+//
+func sqlnull(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+//
+//  rederive2ld -- 2LD, list section and status of a host name as stored
+//
+func rederive2ld(TLDinfo *util.DomainSuffixes, stored string) (string, util.Suffixsection, util.Hoststatus) {
+	name, class, err := util.NormalizeHostname(stored) // strips "*." of wildcards
+	if err != nil {
+		return "", util.Sectionnone, util.Hostbadidn
+	}
+	return hostname2ld(TLDinfo, name, class)
+}
+
+//
+//  Certs per transaction when rederiving. Each cert's writes go in one
+//  transaction, so a failure never leaves a cert half updated.
+//
+const rederivebatch = 1000
+
+//
+//  rederivewriter -- updates for Rederive, in transactions of rederivebatch certs
+//
+type rederivewriter struct {
+	dbcon   *sql.DB
+	stmts   []*sql.Stmt // prepared on dbcon
+	tx      *sql.Tx     // current transaction, if any
+	txstmts []*sql.Stmt // stmts for use in tx
+	certs   int         // certs written in current transaction
+}
+
+//
+//  newrederivewriter -- prepare statements, used by number in exec
+//
+func newrederivewriter(dbcon *sql.DB, queries ...string) (*rederivewriter, error) {
+	w := &rederivewriter{dbcon: dbcon}
+	for _, query := range queries {
+		stmt, err := dbcon.Prepare(query)
+		if err != nil {
+			w.close()
+			return nil, err
+		}
+		w.stmts = append(w.stmts, stmt)
+	}
+	return w, nil
+}
+
+//
+//  exec -- run statement n in the current transaction, starting one if needed
+//
+func (w *rederivewriter) exec(n int, args ...interface{}) error {
+	if w.tx == nil {
+		tx, err := w.dbcon.Begin()
+		if err != nil {
+			return err
+		}
+		w.tx = tx
+		w.txstmts = w.txstmts[:0]
+		for _, stmt := range w.stmts {
+			w.txstmts = append(w.txstmts, tx.Stmt(stmt))
+		}
+	}
+	_, err := w.txstmts[n].Exec(args...)
+	return err
+}
+
+//
+//  endcert -- all writes for one cert done; commit if the batch is full
+//
+func (w *rederivewriter) endcert() error {
+	w.certs++
+	if w.certs < rederivebatch {
+		return nil
+	}
+	return w.commit()
+}
+
+//
+//  commit -- commit current transaction, if any
+//
+func (w *rederivewriter) commit() error {
+	w.certs = 0
+	if w.tx == nil {
+		return nil
+	}
+	err := w.tx.Commit()
+	w.tx = nil
+	return err
+}
+
+//
+//  close -- roll back anything not committed, and release statements
+//
+func (w *rederivewriter) close() {
+	if w.tx != nil {
+		w.tx.Rollback() // only after an error, which is already being returned
+		w.tx = nil
+	}
+	for _, stmt := range w.stmts {
+		stmt.Close()
+	}
+}
+
+//
+//  rederivechanges -- host names moved, keyed by old and new 2LD
+//
+type rederivechanges map[[2]string]*Rederivechange
+
+//
+//  note -- count one host name moved from old2ld to new2ld
+//
+func (c rederivechanges) note(old2ld string, new2ld string, name string) {
+	key := [2]string{old2ld, new2ld}
+	change := c[key]
+	if change == nil {
+		change = &Rederivechange{Old2ld: old2ld, New2ld: new2ld, Example: name}
+		c[key] = change
+	}
+	change.Hostnames++
+}
+
+//
+//  list -- changes, most host names first, then by old and new 2LD
+//
+func (c rederivechanges) list() []Rederivechange {
+	list := make([]Rederivechange, 0, len(c))
+	for _, change := range c {
+		list = append(list, *change)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Hostnames != list[j].Hostnames {
+			return list[i].Hostnames > list[j].Hostnames
+		}
+		if list[i].Old2ld != list[j].Old2ld {
+			return list[i].Old2ld < list[j].Old2ld
+		}
+		return list[i].New2ld < list[j].New2ld
+	})
+	return list
+}
+
+//
+//  storedname -- a hostnames row as stored
+//
+type storedname struct {
+	Hostname  string // normalized, "*." if wildcard
+	Domain2ld string // "" if NULL
+	Section   string // "" if NULL
+	Status    string // Host_status
+}
+
+//
+//  rederivehostnames -- derive a cert's 2LDs again from its stored host names
+//
+//  Returns the names whose 2LD, section or status changed, with their
+//  new values, and all 2LDs of the cert with the new list.
+//
+func rederivehostnames(TLDinfo *util.DomainSuffixes, stored []storedname) ([]Hostnameinfo, map[string]util.Suffixsection) {
+	var changed []Hostnameinfo
+	domains := make(map[string]util.Suffixsection)
+	for _, old := range stored {
+		new2ld, section, status := rederive2ld(TLDinfo, old.Hostname)
+		if new2ld != "" {
+			domains[new2ld] = section
+		}
+		if new2ld == old.Domain2ld && section.String() == old.Section && status.String() == old.Status { // no change
+			continue
+		}
+		changed = append(changed, Hostnameinfo{Name: old.Hostname, Domain2ld: new2ld, Section: section, Status: status})
+	}
+	return changed, domains
+}
+
+//
+//  rederivedomains -- derive a cert's 2LDs again from its stored 2LDs, when it has no host names
+//
+//  stored is 2LD -> section, as in the domains table. Returns the new
+//  2LDs, whether they differ from the stored ones, and false if some
+//  stored 2LD is now a suffix, so its 2LD can't be known without the
+//  name it came from.
+//
+func rederivedomains(TLDinfo *util.DomainSuffixes, stored map[string]string) (map[string]util.Suffixsection, bool, bool) {
+	domains := make(map[string]util.Suffixsection)
+	changed := false
+	for old2ld, oldsection := range stored {
+		new2ld, section, _ := rederive2ld(TLDinfo, old2ld)
+		if new2ld == "" {
+			return nil, false, false // needs the labels below it, which weren't kept
+		}
+		domains[new2ld] = section
+		changed = changed || new2ld != old2ld || section.String() != oldsection
+	}
+	return domains, changed, true
+}
+
+//
+//  Statements of Rederive's writer, by number.
+//
+const (
+	rdhostupdate = iota
+	rddomaindelete
+	rddomaininsert
+)
+
+//
+//  Rederive -- derive all 2LDs again from stored host names
+//
+//  Certs with no stored host names are derived again from their stored
+//  2LDs, as far as that can be done; Rederivereport.Complete says if
+//  every cert was. Updates are committed every rederivebatch certs; if
+//  Rederive fails part way, running it again finishes the job.
+//
+func (d *Certdb) Rederive(TLDinfo *util.DomainSuffixes) (Rederivereport, error) {
+	var report Rederivereport
+	changes := make(rederivechanges)
+	w, err := newrederivewriter(d.dbcon,
+		"UPDATE hostnames SET Domain_2ld = ?, Suffix_section = ?, Host_status = ? WHERE Certificate_id = ? AND Hostname = ?",
+		"DELETE FROM domains WHERE Certificate_id = ?",
+		"INSERT INTO domains (Certificate_id, Domain_2ld, Suffix_section) VALUES (?, ?, ?)")
+	if err != nil {
+		return report, err
+	}
+	defer w.close()
+	//  replacedomains -- replace the domains rows of one cert, as the last writes for it
+	replacedomains := func(certid int64, domains map[string]util.Suffixsection) error {
+		report.Certschanged++
+		err := w.exec(rddomaindelete, certid)
+		if err != nil {
+			return err
+		}
+		for domain2ld, section := range domains {
+			err = w.exec(rddomaininsert, certid, domain2ld, sqlnull(section.String()))
+			if err != nil {
+				return err
+			}
+		}
+		return w.endcert()
+	}
+	//  Host names, one cert at a time. A cert's domains rows are replaced if any of its names changed.
+	var certid int64 = -1   // current cert
+	var stored []storedname // hostnames rows of current cert
+	flush := func() error {
+		if len(stored) == 0 {
+			return nil
+		}
+		report.Hostnames += int64(len(stored))
+		olds := make(map[string]string) // host name -> old 2LD
+		for _, old := range stored {
+			olds[old.Hostname] = old.Domain2ld
+		}
+		changed, domains := rederivehostnames(TLDinfo, stored)
+		if len(changed) == 0 {
+			return nil
+		}
+		for _, h := range changed {
+			report.Hostnameschanged++
+			err := w.exec(rdhostupdate, sqlnull(h.Domain2ld), sqlnull(h.Section.String()), h.Status.String(), certid, h.Name)
+			if err != nil {
+				return err
+			}
+			if old2ld := olds[h.Name]; old2ld != h.Domain2ld { // section and status changes are not listed
+				changes.note(old2ld, h.Domain2ld, h.Name)
+			}
+		}
+		return replacedomains(certid, domains)
+	}
+	rows, err := d.dbcon.Query("SELECT Certificate_id, Hostname, Domain_2ld, Suffix_section, Host_status FROM hostnames ORDER BY Certificate_id")
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var name string
+		var old2ld, oldsection, oldstatus sql.NullString
+		err = rows.Scan(&id, &name, &old2ld, &oldsection, &oldstatus)
+		if err != nil {
+			return report, err
+		}
+		if id != certid { // next cert
+			err = flush()
+			if err != nil {
+				return report, err
+			}
+			certid = id
+			stored = stored[:0]
+		}
+		stored = append(stored, storedname{Hostname: name, Domain2ld: old2ld.String, Section: oldsection.String, Status: oldstatus.String})
+	}
+	err = rows.Err()
+	if err != nil {
+		return report, err
+	}
+	err = flush() // last cert
+	if err != nil {
+		return report, err
+	}
+	err = w.commit()
+	if err != nil {
+		return report, err
+	}
+	err = d.rederivenohostnames(TLDinfo, w, replacedomains, &report)
+	if err != nil {
+		return report, err
+	}
+	err = d.rederivecommonnames(TLDinfo, &report)
+	if err != nil {
+		return report, err
+	}
+	report.Changes = changes.list()
+	return report, nil
+}
+
+//
+//  rederivenohostnames -- derive 2LDs again for certs loaded before the hostnames table
+//
+func (d *Certdb) rederivenohostnames(TLDinfo *util.DomainSuffixes, w *rederivewriter,
+	replacedomains func(int64, map[string]util.Suffixsection) error, report *Rederivereport) error {
+	var certid int64 = -1             // current cert
+	stored := make(map[string]string) // domains rows of current cert, 2LD -> section
+	flush := func() error {
+		if len(stored) == 0 {
+			return nil
+		}
+		report.Certsnohostnames++
+		domains, changed, ok := rederivedomains(TLDinfo, stored)
+		if !ok {
+			report.Certsunrederivable++
+			return nil
+		}
+		if !changed {
+			return nil
+		}
+		return replacedomains(certid, domains)
+	}
+	rows, err := d.dbcon.Query("SELECT Certificate_id, Domain_2ld, Suffix_section FROM domains d " +
+		"WHERE NOT EXISTS (SELECT 1 FROM hostnames h WHERE h.Certificate_id = d.Certificate_id) ORDER BY Certificate_id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var domain2ld string
+		var section sql.NullString
+		err = rows.Scan(&id, &domain2ld, &section)
+		if err != nil {
+			return err
+		}
+		if id != certid { // next cert
+			err = flush()
+			if err != nil {
+				return err
+			}
+			certid = id
+			stored = make(map[string]string)
+		}
+		stored[domain2ld] = section.String
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+	err = flush() // last cert
+	if err != nil {
+		return err
+	}
+	return w.commit()
+}
+
+//
+//  rederivecommonnames -- update Subject_commonname_2ld of certs
+//
+func (d *Certdb) rederivecommonnames(TLDinfo *util.DomainSuffixes, report *Rederivereport) error {
+	w, err := newrederivewriter(d.dbcon, "UPDATE certs SET Subject_commonname_2ld = ? WHERE Certificate_id = ?")
+	if err != nil {
+		return err
+	}
+	defer w.close()
+	rows, err := d.dbcon.Query("SELECT Certificate_id, Subject_commonname, Subject_commonname_2ld FROM certs WHERE Subject_commonname IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var cn string
+		var old2ld sql.NullString
+		err = rows.Scan(&id, &cn, &old2ld)
+		if err != nil {
+			return err
+		}
+		new2ld, _, _ := rederive2ld(TLDinfo, cn)
+		if new2ld == old2ld.String {
+			continue
+		}
+		report.Commonnames++
+		err = w.exec(0, sqlnull(new2ld), id)
+		if err != nil {
+			return err
+		}
+		err = w.endcert()
+		if err != nil {
+			return err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+	return w.commit()
+}
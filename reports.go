@@ -0,0 +1,379 @@
+//
+//  reports.go -- reports accumulated over a run
+//
+//  John Nagle
+//  SiteTruth
+//
+package main
+
+import "fmt"
+import "sort"
+import "strings"
+import "certscan/certumich"
+import "certscan/util"
+
+//
+//  homographitem -- one lookalike 2LD, for the homograph report
+//
+type homographitem struct {
+	util.Homographmatch        // what it looks like
+	certs               int64  // number of certs with it
+	example             string // CN of first cert with it
+}
+
+var homographitems = make(map[string]*homographitem) // keyed by lookalike 2LD
+
+//
+//  checkhomographs -- check a cert's 2LDs for lookalikes, and note any for the report
+//
+//  Returns true if any 2LD looks like a brand or another 2LD.
+//
+func checkhomographs(c *certumich.Processedcert) bool {
+	found := false
+	for i := range c.Domains2ld {
+		match, ok := Homographs.Check(c.Domains2ld[i])
+		if !ok {
+			continue
+		}
+		found = true
+		item := homographitems[match.Domain]
+		if item == nil {
+			item = &homographitem{Homographmatch: match, example: c.Subject_commonname}
+			homographitems[match.Domain] = item
+		}
+		item.certs++
+		if cmdopts.verbose {
+			fmt.Printf("Homograph: '%s' looks like '%s'\n", match.Domain, match.Lookalike)
+		}
+	}
+	return found
+}
+
+//
+//  printhomographs -- print homograph report, brand lookalikes first
+//
+func printhomographs() {
+	items := make([]*homographitem, 0, len(homographitems))
+	for _, item := range homographitems {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Brand != items[j].Brand {
+			return items[i].Brand
+		}
+		return items[i].certs > items[j].certs
+	})
+	fmt.Printf("Homographs: %d lookalike second level domains.\n", len(items))
+	for _, item := range items {
+		source := "dataset"
+		if item.Brand {
+			source = "brand"
+		}
+		script := ""
+		if item.Mixedscript {
+			script = ", mixed script"
+		}
+		fmt.Printf(" '%s' looks like '%s' (%s%s) in %d certs, e.g. '%s'\n",
+			item.Domain, item.Lookalike, source, script, item.certs, item.example)
+	}
+}
+
+//
+//  typosquatitem -- one near miss 2LD, for the typosquat report
+//
+type typosquatitem struct {
+	util.Typosquatmatch        // what it is a near miss of, and why
+	certs               int64  // number of certs with it
+	example             string // CN of first cert with it
+}
+
+var typosquatitems = make(map[string]*typosquatitem) // keyed by near miss 2LD
+
+//
+//  checktyposquats -- check a cert's 2LDs for near misses of brands, and note any for the report
+//
+//  Returns true if any 2LD is a near miss.
+//
+func checktyposquats(c *certumich.Processedcert) bool {
+	found := false
+	for i := range c.Domains2ld {
+		match, ok := Typosquats.Check(c.Domains2ld[i])
+		if !ok {
+			continue
+		}
+		found = true
+		item := typosquatitems[match.Domain]
+		if item == nil {
+			item = &typosquatitem{Typosquatmatch: match, example: c.Subject_commonname}
+			typosquatitems[match.Domain] = item
+		}
+		item.certs++
+		if cmdopts.verbose {
+			fmt.Printf("Typosquat: '%s' is a near miss of '%s' (%s)\n", match.Domain, match.Brand, match.Rule)
+		}
+	}
+	return found
+}
+
+//
+//  printtyposquats -- print typosquat report, grouped by brand
+//
+func printtyposquats() {
+	items := make([]*typosquatitem, 0, len(typosquatitems))
+	for _, item := range typosquatitems {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Brand != items[j].Brand {
+			return items[i].Brand < items[j].Brand
+		}
+		return items[i].certs > items[j].certs
+	})
+	fmt.Printf("Typosquats: %d near miss second level domains.\n", len(items))
+	for _, item := range items {
+		fmt.Printf(" '%s' near miss of '%s' (%s) in %d certs, e.g. '%s'\n",
+			item.Domain, item.Brand, item.Rule, item.certs, item.example)
+	}
+}
+
+//
+//  unknownoiditem -- one policy OID not in the OID table, for the unknown OID report
+//
+type unknownoiditem struct {
+	oid      string           // the OID
+	certs    int64            // number of certs with it
+	issuers  map[string]int64 // certs by issuer
+	levels   map[string]int64 // certs by inferred level
+	examples []string         // CNs of first few certs with it
+}
+
+var unknownoiditems = make(map[string]*unknownoiditem) // keyed by OID
+
+const unknownoidexamples = 3 // example subjects per OID
+const unknownoidissuers = 3  // top issuers per OID
+
+//
+//  checkunknownoids -- note a cert's policy OIDs which are not in the OID table
+//
+func checkunknownoids(c *certumich.Processedcert) {
+	issuer := c.Issuerorg()
+	for _, oid := range c.Policies {
+		if _, ok := CAinfo.Getpolicy(oid); ok {
+			continue
+		}
+		item := unknownoiditems[oid]
+		if item == nil {
+			item = &unknownoiditem{oid: oid, issuers: make(map[string]int64), levels: make(map[string]int64)}
+			unknownoiditems[oid] = item
+		}
+		item.certs++
+		item.issuers[issuer]++
+		if c.Inferred.Level != "" {
+			item.levels[c.Inferred.Level]++
+		}
+		if len(item.examples) < unknownoidexamples && c.Subject_commonname != "" {
+			item.examples = append(item.examples, c.Subject_commonname)
+		}
+	}
+}
+
+//
+//  topissuers -- issuers with the most certs with the OID, most first
+//
+func (item *unknownoiditem) topissuers() []string {
+	issuers := make([]string, 0, len(item.issuers))
+	for issuer := range item.issuers {
+		issuers = append(issuers, issuer)
+	}
+	sort.Slice(issuers, func(i, j int) bool {
+		if item.issuers[issuers[i]] != item.issuers[issuers[j]] {
+			return item.issuers[issuers[i]] > item.issuers[issuers[j]]
+		}
+		return issuers[i] < issuers[j]
+	})
+	if len(issuers) > unknownoidissuers {
+		issuers = issuers[:unknownoidissuers]
+	}
+	return issuers
+}
+
+//
+//  level -- most common inferred level of certs with the OID, "" if none
+//
+func (item *unknownoiditem) level() string {
+	best := ""
+	for _, level := range util.Policylevels {
+		if item.levels[level] > item.levels[best] {
+			best = level
+		}
+	}
+	return best
+}
+
+//
+//  sortedunknownoids -- unknown OIDs, most certs first
+//
+func sortedunknownoids() []*unknownoiditem {
+	items := make([]*unknownoiditem, 0, len(unknownoiditems))
+	for _, item := range unknownoiditems {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].certs != items[j].certs {
+			return items[i].certs > items[j].certs
+		}
+		return items[i].oid < items[j].oid
+	})
+	return items
+}
+
+//
+//  unknownoids -- unknown OIDs seen
+//
+func unknownoids() []string {
+	oids := make([]string, 0, len(unknownoiditems))
+	for oid := range unknownoiditems {
+		oids = append(oids, oid)
+	}
+	return oids
+}
+
+//
+//  printunknownoids -- print unknown OID report, most certs first
+//
+func printunknownoids() {
+	items := sortedunknownoids()
+	fmt.Printf("Unknown policy OIDs: %d OIDs not in OID table.\n", len(items))
+	for _, item := range items {
+		fmt.Printf(" %s in %d certs", item.oid, item.certs)
+		if owner, ok := CAinfo.Arcowner(item.oid); ok {
+			fmt.Printf(", under enterprise arc of '%s'", owner)
+		} else if org, ok := Enterprisenumbers.Organization(item.oid); ok {
+			fmt.Printf(", enterprise number registered to '%s'", org)
+		}
+		if level := item.level(); level != "" {
+			fmt.Printf(", mostly inferred %s", level)
+		}
+		fmt.Printf("\n")
+		for _, issuer := range item.topissuers() {
+			fmt.Printf("   Issuer '%s': %d certs\n", issuer, item.issuers[issuer])
+		}
+		for _, example := range item.examples {
+			fmt.Printf("   e.g. '%s'\n", example)
+		}
+	}
+}
+
+//
+//  writeunknownoids -- write unknown OIDs as rows of the CSV OID table, for triage
+//
+//  The CA is the owner of the enterprise arc if in the OID table, else the
+//  organization registered for the enterprise number, else the top issuer.
+//  The level is the one most often inferred, else OV. Both need checking,
+//  so the notes say how they were picked.
+//
+func writeunknownoids(outfilename string) error {
+	items := sortedunknownoids()
+	entries := make([]util.Policyentry, 0, len(items))
+	for _, item := range items {
+		issuers := item.topissuers()
+		caname, ok := CAinfo.Arcowner(item.oid)
+		how := "CA from enterprise arc"
+		if !ok {
+			caname, ok = Enterprisenumbers.Organization(item.oid)
+			how = "CA from enterprise number registry"
+		}
+		if !ok {
+			caname = issuers[0]
+			how = "CA is top issuer"
+		}
+		level := item.level()
+		if level == "" {
+			level = "OV"
+			how += ", level unknown"
+		} else {
+			how += ", level inferred"
+		}
+		notes := fmt.Sprintf("UNKNOWN OID: %d certs, %s; issuers: %s; e.g. %s", item.certs, how,
+			strings.Join(issuers, ", "), strings.Join(item.examples, ", "))
+		entries = append(entries, util.Policyentry{CAname: caname, Level: level, OID: item.oid, Notes: notes})
+	}
+	return util.Writeoidcsv(outfilename, "Unknown policy OIDs found by certscan", entries)
+}
+
+//
+//  evissueritem -- EV certs of one issuer, for the EV report
+//
+type evissueritem struct {
+	issuer   string           // issuer name
+	certs    int64            // certs classified EV
+	failing  int64            // certs with findings
+	byrule   map[string]int64 // certs by finding
+	examples []string         // CNs of first few failing certs
+}
+
+var evissueritems = make(map[string]*evissueritem) // keyed by issuer
+
+const evexamples = 3 // example subjects per issuer
+
+//
+//  noteevfindings -- note a cert classified EV, with its findings, for the report
+//
+func noteevfindings(c *certumich.Processedcert) {
+	if c.Classified.Level != "EV" {
+		return
+	}
+	issuer := c.Issuerorg()
+	item := evissueritems[issuer]
+	if item == nil {
+		item = &evissueritem{issuer: issuer, byrule: make(map[string]int64)}
+		evissueritems[issuer] = item
+	}
+	item.certs++
+	if len(c.Evfindings) == 0 {
+		return
+	}
+	item.failing++
+	seen := make(map[string]bool) // count each rule once per cert
+	for _, f := range c.Evfindings {
+		rule := f.Rule.String()
+		if !seen[rule] {
+			seen[rule] = true
+			item.byrule[rule]++
+		}
+	}
+	if len(item.examples) < evexamples && c.Subject_commonname != "" {
+		item.examples = append(item.examples, c.Subject_commonname)
+	}
+}
+
+//
+//  printevfindings -- print EV report, issuers with most failing certs first
+//
+func printevfindings() {
+	items := make([]*evissueritem, 0, len(evissueritems))
+	for _, item := range evissueritems {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].failing != items[j].failing {
+			return items[i].failing > items[j].failing
+		}
+		return items[i].issuer < items[j].issuer
+	})
+	fmt.Printf("EV subject checks: %d issuers of certs classified EV.\n", len(items))
+	for _, item := range items {
+		fmt.Printf(" '%s': %d of %d EV certs fail\n", item.issuer, item.failing, item.certs)
+		rules := make([]string, 0, len(item.byrule))
+		for rule := range item.byrule {
+			rules = append(rules, rule)
+		}
+		sort.Strings(rules)
+		for _, rule := range rules {
+			fmt.Printf("   %-24s %12d\n", rule+":", item.byrule[rule])
+		}
+		for _, example := range item.examples {
+			fmt.Printf("   e.g. '%s'\n", example)
+		}
+	}
+}
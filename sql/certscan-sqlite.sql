@@ -0,0 +1,149 @@
+--
+--  certscan-sqlite.sql -- tables for SSL certificate analysis, SQLite version
+--
+--  Same tables and columns, in the same order, as certscan.sql, which
+--  has the comments. ENUM columns are TEXT here, with the same values.
+--
+--    sqlite3 certs.db < sql/certscan-sqlite.sql
+--    certscan -db sqlite://certs.db ...
+--
+DROP TABLE IF EXISTS certs;
+DROP TABLE IF EXISTS domains;
+DROP TABLE IF EXISTS policies;
+DROP TABLE IF EXISTS capolicies;
+DROP TABLE IF EXISTS issuers;
+DROP TABLE IF EXISTS runs;
+DROP TABLE IF EXISTS hostnames;
+DROP TABLE IF EXISTS evfindings;
+--
+--  certs - fields of interest from U. Mich. certificate dump
+--
+CREATE TABLE certs (
+    Certificate_id                  BIGINT PRIMARY KEY NOT NULL,
+    Serial_number                   BIGINT,
+    Issuer_id                       BIGINT,
+    Version                         TEXT,
+    Is_ca                           BOOL,
+    Is_self_signed                  BOOL,
+    Not_valid_before_time           DATETIME,
+    Not_valid_after_time            DATETIME,
+    Is_valid                        BOOL,
+    OpenSSL_validation_error        TEXT,
+    Is_ubuntu_valid                 BOOL,
+    Is_mozilla_valid                BOOL,
+    Is_windows_valid                BOOL,
+    Is_apple_valid                  BOOL,
+    Depth                           SMALLINT,
+    Is_revoked                      BOOL,
+    Reason_revoked                  TEXT,
+    Issuer_name                     VARCHAR(255),
+    Subject_commonname              VARCHAR(255),
+    Subject_commonname_2ld          VARCHAR(255),
+    Subject_organization            TEXT,
+    Subject_organizationunit        TEXT,
+    Subject_location                TEXT,
+    Subject_countrycode             TEXT,
+    Is_browser_valid                BOOL,
+    Error_message                   TEXT,
+    Source_file                     VARCHAR(255),
+    Record_number                   BIGINT,
+    Byte_offset                     BIGINT,
+    Relatedness                     FLOAT,
+    Inferred_level                  TEXT,           -- 'DV', 'OV', 'IV', 'EV'
+    Inferred_confidence             FLOAT,
+    Inferred_reason                 VARCHAR(255),
+    Policy_level                    TEXT,           -- 'DV', 'OV', 'IV', 'EV'
+    Policy_oids                     TEXT,
+    Policy_anomalies                TEXT,
+    Subject_state                   TEXT,
+    Subject_businesscategory        VARCHAR(64),
+    Subject_serialnumber            VARCHAR(64),
+    Subject_jurisdictioncountry     TEXT,
+    Subject_jurisdictionstate       TEXT,
+    Subject_jurisdictionlocality    TEXT
+);
+CREATE INDEX certs_commonname_2ld ON certs (Subject_commonname_2ld);
+CREATE INDEX certs_issuer_name ON certs (Issuer_name);
+CREATE INDEX certs_relatedness ON certs (Relatedness);
+CREATE INDEX certs_policy_level ON certs (Policy_level);
+--
+--  domains  -- Domains associated with certificates above.
+--
+CREATE TABLE domains (
+    Certificate_id                  BIGINT NOT NULL,
+    Domain_2ld                      VARCHAR(255) NOT NULL,
+    Suffix_section                  TEXT,           -- 'ICANN', 'PRIVATE'
+    UNIQUE (Certificate_id, Domain_2ld)
+);
+--
+--  hostnames -- all names (CN and subjectAltName) of certificates above
+--
+CREATE TABLE hostnames (
+    Certificate_id                  BIGINT NOT NULL,
+    Hostname                        VARCHAR(255) NOT NULL,
+    Domain_2ld                      VARCHAR(255),
+    Suffix_section                  TEXT,           -- 'ICANN', 'PRIVATE'
+    Host_status                     TEXT,           -- 'OK', 'UNDERSCORE', 'IP', etc.
+    UNIQUE (Certificate_id, Hostname)
+);
+CREATE INDEX hostnames_domain_2ld ON hostnames (Domain_2ld);
+--
+--  evfindings -- EV Guidelines subject problems of certs classified EV
+--
+CREATE TABLE evfindings (
+    Certificate_id                  BIGINT NOT NULL,
+    Finding                         TEXT NOT NULL,  -- 'NOORGANIZATION', 'NOBUSINESSCATEGORY', etc.
+    Detail                          VARCHAR(255)
+);
+CREATE INDEX evfindings_certificate_id ON evfindings (Certificate_id);
+CREATE INDEX evfindings_finding ON evfindings (Finding);
+--
+--  policies --  Certificate policy OIDs associated with certificates above
+--
+CREATE TABLE policies (
+    Certificate_id                  BIGINT NOT NULL,
+    OID                             VARCHAR(30) NOT NULL,
+    CPS_url                         TEXT,
+    User_notice                     TEXT,
+    UNIQUE (Certificate_id, OID)
+);
+--
+--  capolicies -- policy OIDs associated with CAs.
+--
+CREATE TABLE capolicies (
+    OID                             VARCHAR(30) NOT NULL PRIMARY KEY,
+    Issuer_name                     VARCHAR(255),
+    certlevel                       TEXT,           -- 'DV', 'OV', 'IV', 'EV'
+    CPS_url                         TEXT,
+    Notes                           TEXT,
+    Source                          VARCHAR(255),
+    Purpose                         TEXT NOT NULL DEFAULT 'TLS',  -- 'TLS', 'CODESIGNING', 'SMIME', 'TIMESTAMPING', 'TEST'
+    Loaded_as                       VARCHAR(512)
+);
+--
+--  issuers -- issuing CAs of certificates above, keyed by Issuer_id
+--
+CREATE TABLE issuers (
+    Issuer_id                       BIGINT PRIMARY KEY NOT NULL,
+    Issuer_name                     VARCHAR(255),
+    Issuer_organization             VARCHAR(255),
+    Issuer_organizationunit         TEXT,
+    Issuer_countrycode              TEXT,
+    Authority_key_id                VARCHAR(128),
+    Issuer_in_dataset               BOOL
+);
+CREATE INDEX issuers_organization ON issuers (Issuer_organization);
+CREATE INDEX issuers_authority_key_id ON issuers (Authority_key_id);
+--
+--  runs -- one row per run loading certs, with the public suffix list used
+--
+CREATE TABLE runs (
+    Run_id                          INTEGER PRIMARY KEY AUTOINCREMENT,
+    Run_time                        DATETIME NOT NULL,
+    Psl_file                        TEXT,
+    Psl_version                     VARCHAR(64),
+    Psl_commit                      VARCHAR(64),
+    Psl_hash                        CHAR(64),
+    Psl_private                     BOOL,
+    Run_kind                        TEXT NOT NULL DEFAULT 'LOAD'  -- 'LOAD', 'REDERIVE'
+);
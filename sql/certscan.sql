@@ -9,7 +9,7 @@
 --  UTF-8 everywhere
 --
 USE sslcerts;
-DROP TABLE IF EXISTS certs, domains, policies, capolicies;
+DROP TABLE IF EXISTS certs, domains, policies, capolicies, issuers, runs, hostnames, evfindings;
 ALTER DATABASE sslcerts DEFAULT collate utf8_general_ci DEFAULT character set utf8;
 --
 --  certs - fields of interest from U. Mich. certificate dump
@@ -70,8 +70,29 @@ CREATE TABLE certs (
     Subject_countrycode             TEXT(2),
     Is_browser_valid                BOOL,  -- at least one major browser vendor accepts this cert
     Error_message                   TEXT,
+    Source_file                     VARCHAR(255),   -- input file, if loaded with -provenance
+    Record_number                   BIGINT,         -- CSV record number in input file
+    Byte_offset                     BIGINT,         -- byte offset of record in input file
+    Relatedness                     FLOAT,          -- relatedness of the cert's 2LDs, 0 (unrelated) to 1
+    --  Validation level guessed from subject and issuer, not from policy OIDs (see capolicies)
+    Inferred_level                  ENUM('DV', 'OV', 'IV', 'EV'),
+    Inferred_confidence             FLOAT,          -- 0 to 1
+    Inferred_reason                 VARCHAR(255),   -- rule which decided
+    --  Validation level from policy OIDs and the OID table (see capolicies)
+    Policy_level                    ENUM('DV', 'OV', 'IV', 'EV'),  -- NULL if no known OID
+    Policy_oids                     TEXT,           -- OIDs which decided, space separated
+    Policy_anomalies                TEXT,           -- OIDs of another CA, contradictory levels, etc.
+    Subject_state                   TEXT,
+    --  EV Guidelines subject attributes
+    Subject_businesscategory        VARCHAR(64),    -- "Private Organization", etc.
+    Subject_serialnumber            VARCHAR(64),    -- registration number of organization
+    Subject_jurisdictioncountry     TEXT(2),
+    Subject_jurisdictionstate       TEXT,
+    Subject_jurisdictionlocality    TEXT,
     INDEX (Subject_commonname_2ld),
-    INDEX (Issuer_name)
+    INDEX (Issuer_name),
+    INDEX (Relatedness),
+    INDEX (Policy_level)
 );
 
 --
@@ -83,14 +104,44 @@ CREATE TABLE certs (
 CREATE TABLE domains (
     Certificate_id                  BIGINT NOT NULL,
     Domain_2ld                      VARCHAR(255) NOT NULL,  -- "2ld.tld", no subdomains
+    Suffix_section                  ENUM('ICANN', 'PRIVATE'),  -- public suffix list section of "tld"
     UNIQUE INDEX (Certificate_id, Domain_2ld)
 );
 --
+--  hostnames -- all names (CN and subjectAltName) of certificates above
+--
+--  Kept so Domain_2ld here, in domains, and in certs can be derived
+--  again with a new public suffix list ("certscan -rederive").
+--
+CREATE TABLE hostnames (
+    Certificate_id                  BIGINT NOT NULL,
+    Hostname                        VARCHAR(255) NOT NULL,  -- normalized, "*." if wildcard
+    Domain_2ld                      VARCHAR(255),           -- "2ld.tld", NULL if not a domain
+    Suffix_section                  ENUM('ICANN', 'PRIVATE'),
+    Host_status                     ENUM('OK', 'UNDERSCORE', 'IP', 'NOTHOSTNAME', 'BADIDN', 'EMPTYLABEL', 'TOOLONG',
+                                        'LABELTOOLONG', 'BADCHAR', 'HYPHEN', 'INTERNAL', 'NOSUFFIX'),  -- not used for 2LDs unless OK or UNDERSCORE
+    UNIQUE INDEX (Certificate_id, Hostname),
+    INDEX (Domain_2ld)
+);
+--
+--  evfindings -- EV Guidelines subject problems of certs classified EV
+--
+CREATE TABLE evfindings (
+    Certificate_id                  BIGINT NOT NULL,
+    Finding                         ENUM('NOORGANIZATION', 'NOBUSINESSCATEGORY', 'BADBUSINESSCATEGORY', 'NOSERIALNUMBER',
+                                        'NOJURISDICTIONCOUNTRY', 'NOCOUNTRY', 'NOLOCALITY', 'WILDCARD') NOT NULL,
+    Detail                          VARCHAR(255),   -- offending value, if any
+    INDEX (Certificate_id),
+    INDEX (Finding)
+);
+--
 --  policies --  Certificate policy OIDs associated with certificates above
 --
     CREATE TABLE policies (
         Certificate_id                  BIGINT NOT NULL,
         OID                             VARCHAR(30) NOT NULL,
+        CPS_url                         TEXT,   -- CPS URIs from policy qualifiers, space separated
+        User_notice                     TEXT,   -- user notice explicit text
         UNIQUE INDEX (Certificate_id, OID)
 );
 --
@@ -99,6 +150,41 @@ CREATE TABLE domains (
     CREATE TABLE capolicies (
         OID                         VARCHAR(30) NOT NULL PRIMARY KEY,
         Issuer_name                 VARCHAR(255),
-        certlevel ENUM('DV', 'OV', 'EV') NOT NULL
+        certlevel ENUM('DV', 'OV', 'IV', 'EV'),     -- NULL for purposes with no validation
+        CPS_url                     TEXT,           -- CPS document of CA for this OID
+        Notes                       TEXT,
+        Source                      VARCHAR(255),   -- where in OID table, "catypetable.csv line 7"
+        Purpose                     ENUM('TLS', 'CODESIGNING', 'SMIME', 'TIMESTAMPING', 'TEST') NOT NULL DEFAULT 'TLS',
+        Loaded_as                   VARCHAR(512)    -- CA, level and purpose as last loaded; NULL or different if edited here
+);
+--
+--  issuers -- issuing CAs of certificates above, keyed by Issuer_id
+--
+    CREATE TABLE issuers (
+        Issuer_id                   BIGINT PRIMARY KEY NOT NULL,
+        Issuer_name                 VARCHAR(255),   -- CN of issuer
+        Issuer_organization         VARCHAR(255),   -- O of issuer
+        Issuer_organizationunit     TEXT,           -- OU of issuer
+        Issuer_countrycode          TEXT(2),        -- C of issuer
+        Authority_key_id            VARCHAR(128),   -- issuer key ID, hex with colons
+        Issuer_in_dataset           BOOL,           -- issuer's own cert was in the input
+        INDEX (Issuer_organization),
+        INDEX (Authority_key_id)
+);
+--
+--  runs -- one row per run loading certs, with the public suffix list used
+--
+--  2LDs depend on the suffix list, so rows loaded with different lists
+--  are not comparable. A complete -rederive brings all rows to its list,
+--  so only runs from the last REDERIVE on are compared.
+--
+    CREATE TABLE runs (
+        Run_id                      INT AUTO_INCREMENT PRIMARY KEY NOT NULL,
+        Run_time                    DATETIME NOT NULL,
+        Psl_file                    TEXT,           -- suffix list file name
+        Psl_version                 VARCHAR(64),    -- "VERSION:" header of list, if any
+        Psl_commit                  VARCHAR(64),    -- "COMMIT:" header of list, if any
+        Psl_hash                    CHAR(64),       -- SHA-256 of list, hex
+        Psl_private                 BOOL,           -- PRIVATE section used
+        Run_kind                    ENUM('LOAD','REDERIVE') NOT NULL DEFAULT 'LOAD'
 );
-        
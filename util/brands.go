@@ -0,0 +1,82 @@
+//
+//  brands.go -- list of brand domains watched for impersonation
+//
+package util
+
+import "os"
+import "io"
+import "bufio"
+import "fmt"
+import "strings"
+import "errors"
+
+//
+//  Brand -- one brand domain
+//
+type Brand struct {
+	Domain string   // registrable domain, "paypal.com"
+	Name   string   // second level label, "paypal"
+	Owned  []string // other registrable domains of the same owner, "paypalobjects.com"
+}
+
+//
+//  Brandlist -- brand domains, from a brand file
+//
+type Brandlist struct {
+	Brands []Brand // in file order
+}
+
+//
+//  Loadbrands -- load brand file
+//
+//  One brand domain per line, such as "paypal.com", optionally followed
+//  by other domains of the same owner, such as "paypalobjects.com".
+//  Anything after a "#" is a comment. Names are normalized, and must
+//  have a known public suffix.
+//
+func (b *Brandlist) Loadbrands(infile string, tldinfo *DomainSuffixes) error {
+	fi, err := os.Open(infile) // open input file
+	if err != nil {
+		return err
+	}
+	defer func() { // handle close
+		if err := fi.Close(); err != nil {
+			panic(err) // failed close is legit panic
+		}
+	}()
+	b.Brands = nil
+	r := bufio.NewReader(fi) // make a read buffer
+	for {                    // until EOF
+		s, err := r.ReadString('\n') // read a line
+		if err != nil && err != io.EOF {
+			return err // I/O error
+		}
+		if i := strings.IndexByte(s, '#'); i >= 0 { // drop comment
+			s = s[:i]
+		}
+		fields := strings.Fields(s)
+		if len(fields) > 0 {
+			var brand Brand
+			for i, field := range fields {
+				name, class, nerr := NormalizeHostname(field)
+				_, second, tld, ok := tldinfo.Domainparts(name)
+				if nerr != nil || class != Hostname || !ok {
+					return fmt.Errorf("Bad brand domain in brand file %s: '%s'", infile, field)
+				}
+				if i == 0 {
+					brand = Brand{Domain: second + "." + tld, Name: second}
+				} else {
+					brand.Owned = append(brand.Owned, second+"."+tld)
+				}
+			}
+			b.Brands = append(b.Brands, brand)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(b.Brands) < 1 { // did not find any
+		return errors.New("No brands found in brand file: " + infile)
+	}
+	return nil
+}
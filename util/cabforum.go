@@ -0,0 +1,71 @@
+//
+//  cabforum.go -- policy OIDs reserved by the CA/Browser Forum
+//
+//  These mean the same thing whichever CA uses them, so they are known
+//  even if no CA in the OID table lists them. From the CA/B Forum
+//  object registry, "https://cabforum.org/object-registry/".
+//
+package util
+
+//
+//  Certificate purposes, for the OIDs which are not about TLS.
+//
+const (
+	PurposeTLS          = "TLS"
+	PurposeCodesigning  = "CODESIGNING"
+	PurposeSMIME        = "SMIME"
+	PurposeTimestamping = "TIMESTAMPING"
+	PurposeTest         = "TEST"
+)
+
+//
+//  CAname of registry entries.
+//
+const Cabforumname = "CA/Browser Forum"
+
+//
+//  Cabforumoids -- the reserved policy OIDs
+//
+//  Timestamping and test OIDs say nothing about validation, so have no level.
+//
+var Cabforumoids = []Policyentry{
+	{OID: "2.23.140.1.1", Level: "EV", Purpose: PurposeTLS, Notes: "EV Guidelines"},
+	{OID: "2.23.140.1.2.1", Level: "DV", Purpose: PurposeTLS, Notes: "Baseline Requirements, domain validated"},
+	{OID: "2.23.140.1.2.2", Level: "OV", Purpose: PurposeTLS, Notes: "Baseline Requirements, organization validated"},
+	{OID: "2.23.140.1.2.3", Level: "IV", Purpose: PurposeTLS, Notes: "Baseline Requirements, individual validated"},
+	{OID: "2.23.140.1.3", Level: "EV", Purpose: PurposeCodesigning, Notes: "EV code signing"},
+	{OID: "2.23.140.1.4.1", Level: "OV", Purpose: PurposeCodesigning, Notes: "Code signing Baseline Requirements"},
+	{OID: "2.23.140.1.4.2", Level: "", Purpose: PurposeTimestamping, Notes: "Code signing timestamping"},
+	{OID: "2.23.140.1.5.1.1", Level: "DV", Purpose: PurposeSMIME, Notes: "S/MIME mailbox validated, legacy"},
+	{OID: "2.23.140.1.5.1.2", Level: "DV", Purpose: PurposeSMIME, Notes: "S/MIME mailbox validated, multipurpose"},
+	{OID: "2.23.140.1.5.1.3", Level: "DV", Purpose: PurposeSMIME, Notes: "S/MIME mailbox validated, strict"},
+	{OID: "2.23.140.1.5.2.1", Level: "OV", Purpose: PurposeSMIME, Notes: "S/MIME organization validated, legacy"},
+	{OID: "2.23.140.1.5.2.2", Level: "OV", Purpose: PurposeSMIME, Notes: "S/MIME organization validated, multipurpose"},
+	{OID: "2.23.140.1.5.2.3", Level: "OV", Purpose: PurposeSMIME, Notes: "S/MIME organization validated, strict"},
+	{OID: "2.23.140.1.5.3.1", Level: "OV", Purpose: PurposeSMIME, Notes: "S/MIME sponsor validated, legacy"},
+	{OID: "2.23.140.1.5.3.2", Level: "OV", Purpose: PurposeSMIME, Notes: "S/MIME sponsor validated, multipurpose"},
+	{OID: "2.23.140.1.5.3.3", Level: "OV", Purpose: PurposeSMIME, Notes: "S/MIME sponsor validated, strict"},
+	{OID: "2.23.140.1.5.4.1", Level: "IV", Purpose: PurposeSMIME, Notes: "S/MIME individual validated, legacy"},
+	{OID: "2.23.140.1.5.4.2", Level: "IV", Purpose: PurposeSMIME, Notes: "S/MIME individual validated, multipurpose"},
+	{OID: "2.23.140.1.5.4.3", Level: "IV", Purpose: PurposeSMIME, Notes: "S/MIME individual validated, strict"},
+	{OID: "2.23.140.2.1", Level: "", Purpose: PurposeTest, Notes: "Test certificate"},
+}
+
+//
+//  Iscabforumoid -- true if OID is under the CA/B Forum arc
+//
+func Iscabforumoid(oid string) bool {
+	return len(oid) > len("2.23.140.") && oid[:len("2.23.140.")] == "2.23.140."
+}
+
+//
+//  Cabforumentry -- registry entry for an OID, if reserved
+//
+func Cabforumentry(oid string) (Policyentry, bool) {
+	for _, e := range Cabforumoids {
+		if e.OID == oid {
+			return e, true
+		}
+	}
+	return Policyentry{}, false
+}
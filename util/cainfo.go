@@ -10,124 +10,239 @@
 package util
 
 //
-import "os"
-import "io"
-import "bufio"
 import "fmt"
 import "strings"
 import "errors"
 import "regexp"
-import "encoding/csv"
 import "database/sql"
 
 //
 //  PolicyInfo -  for one policy OID
 //
 type Policyinfo struct {
-	Policy string // "DV", "OV", or "EV"
-	CAname string // name of CA
+	Policy  string // "DV", "OV", "IV", or "EV"; "" if purpose has no validation
+	Purpose string // "TLS", "CODESIGNING", "SMIME", "TIMESTAMPING", or "TEST"
+	CAname  string // name of CA
+	CPS     string // CPS URL, if any
+	Notes   string // notes, if any
+	Source  string // where in the OID table this came from
+	Edited  bool   // from a capolicies row edited since loaded
 }
 
 //
 //  CApolicyinfo -- collect info about CAs
 //
 type CApolicyinfo struct {
-	policyOID map[string]Policyinfo // policy lookup
+	policyOID     map[string]Policyinfo // policy lookup
+	pens          *Enterprisenumbers    // enterprise number registry, if any
+	enterpriseOID map[string]Policyinfo // OIDs not in table, named from registry, for capolicies
 }
 
+var reoid = regexp.MustCompile(`^(\d+\.)+\d+$`) // form n.n.n with at least 2 numbers.
 //
-//  parseoids -- parse OIDs from a string
+//  IsOID  -- test if string has OID syntax
 //
-var reoid = regexp.MustCompile(`^(\d+\.)+\d+$`) // form n.n.n with at least 2 numbers.
-func parseoids(s string) []string {
-	oids := make([]string, 0, 3) // accum OIDs here
-	items := strings.Fields(s)   // just split on whitespace, then recognize OIDS
-	for i := range items {
-		match := reoid.FindString(items[i]) // match OID pattern
-		if match != "" {                    // if found OID
-			oids = append(oids, match) // keep it
+func IsOID(s string) bool {
+	return reoid.FindString(s) != "" // true if OID
+}
+
+//
+//  addentries -- add OID table entries to CApolicyinfo
+//
+func (c *CApolicyinfo) addentries(entries []Policyentry) {
+	for _, e := range entries {
+		purpose := e.Purpose
+		if purpose == "" {
+			purpose = PurposeTLS
 		}
-		////fmt.Printf("OID: '%s' -> %s\n", items[i], match) // ***TEMP***
+		c.policyOID[e.OID] = Policyinfo{Policy: e.Level, Purpose: purpose, CAname: e.CAname, CPS: e.CPS, Notes: e.Notes, Source: e.Source, Edited: e.Edited}
 	}
-	return (oids)
 }
 
 //
-//  IsOID  -- test if string has OID syntax
+//  addcabforum -- add the CA/B Forum reserved OIDs, over any in the OID table
 //
-func IsOID(s string) bool {
-	return reoid.FindString(s) != "" // true if OID
+//  Member CAs list the generic OIDs they use in the table too, but the
+//  OIDs are not theirs; whichever CA came last must not own them.
+//
+func (c *CApolicyinfo) addcabforum() {
+	for _, e := range Cabforumoids {
+		e.CAname = Cabforumname
+		e.Source = "CA/B Forum registry"
+		c.addentries([]Policyentry{e})
+	}
 }
 
 //
-//  addoids -- add list of OIDs to CApolicyinfo
+//  IANA private enterprise arc. CAs get their own arc, 1.3.6.1.4.1.N,
+//  and put their policy OIDs under it.
+//
+const enterprisearcprefix = "1.3.6.1.4.1."
+
+//
+//  Enterprisearc -- the private enterprise arc of an OID, "1.3.6.1.4.1.N"
+//
+//  Returns "" if OID is not under one.
 //
-func (c *CApolicyinfo) addoids(caname string, policytype string, oids []string) {
-	for i := range oids {
-		var pol Policyinfo
-		pol.Policy = policytype
-		pol.CAname = caname
-		c.policyOID[oids[i]] = pol
+func Enterprisearc(oid string) string {
+	if !strings.HasPrefix(oid, enterprisearcprefix) {
+		return ""
 	}
+	pen := strings.SplitN(oid[len(enterprisearcprefix):], ".", 2)[0]
+	if pen == "" {
+		return ""
+	}
+	return enterprisearcprefix + pen
 }
 
 //
-//  loadline  -- load one line of policy info
+//  Arcowner -- CA of the known OIDs under the same enterprise arc as OID
 //
-//  Ignores any non-OID info
+//  If more than one CA has OIDs under the arc, the one with the most wins.
 //
-func (c *CApolicyinfo) loadline(fields []string) {
-	if len(fields) < 4 { // not enough fields
-		return
+func (c *CApolicyinfo) Arcowner(oid string) (string, bool) {
+	arc := Enterprisearc(oid)
+	if arc == "" {
+		return "", false
+	}
+	counts := make(map[string]int)
+	for k, v := range c.policyOID {
+		if Enterprisearc(k) == arc {
+			counts[v.CAname]++
+		}
+	}
+	owner := ""
+	for caname, n := range counts {
+		if owner == "" || n > counts[owner] || (n == counts[owner] && caname < owner) {
+			owner = caname
+		}
 	}
-	caname := fields[0]
-	c.addoids(caname, "DV", parseoids(fields[1])) // add DV oids
-	c.addoids(caname, "OV", parseoids(fields[2])) // add DV oids
-	c.addoids(caname, "EV", parseoids(fields[3])) // add DV oids
+	return owner, owner != ""
 }
 
 //
-//  Loadoidinfo -- load policy info from our CSV file
+//  Oidconflict -- an OID which appears more than once in the OID table
+//
+//  A duplicate is the same OID listed again for the same CA and level.
+//  A conflict is the same OID listed for different CAs or levels.
 //
-//  Takes in a CSV file of certification authorities.
-//  Format is: CA name, DV OIDs, OV OIDs, EV OIDs, CPS URL, Notes
+type Oidconflict struct {
+	OID      string   // the OID
+	Sources  []string // where each listing is, in table order
+	CAnames  []string // CA of each listing
+	Levels   []string // level of each listing
+	Conflict bool     // true if CAs or levels differ, false if just a duplicate
+}
+
 //
-//  OID fields may contain multiple OIDs, and non-OID text.
+//  String -- for reports
 //
-func (c *CApolicyinfo) Loadoidinfo(infilename string) error {
-	fi, err := os.Open(infilename) // open input file
-	if err != nil {
-		return err
+func (o Oidconflict) String() string {
+	kind := "Duplicate"
+	if o.Conflict {
+		kind = "Conflicting"
 	}
-	defer func() { // handle close
-		if err := fi.Close(); err != nil {
-			panic(err) // failed close is legit panic
+	listings := make([]string, len(o.Sources))
+	for i := range o.Sources {
+		listings[i] = fmt.Sprintf("%s %s (%s)", o.CAnames[i], o.Levels[i], o.Sources[i])
+	}
+	return fmt.Sprintf("%s OID %s: %s", kind, o.OID, strings.Join(listings, "; "))
+}
+
+//
+//  Findoidconflicts -- find OIDs listed more than once, in table order
+//
+//  CA/B Forum reserved OIDs are shared by every CA, and the registry
+//  entry is used whatever the table says, so a CA listing one is only
+//  an error if it gives another level.
+//
+func Findoidconflicts(entries []Policyentry) []Oidconflict {
+	byoid := make(map[string]*Oidconflict)
+	var order []string // OIDs in table order
+	for _, e := range entries {
+		if reserved, ok := Cabforumentry(e.OID); ok {
+			if e.Level == reserved.Level { // as registered
+				continue
+			}
+			if byoid[e.OID] == nil { // registry listing first
+				byoid[e.OID] = &Oidconflict{OID: e.OID, Sources: []string{"CA/B Forum registry"},
+					CAnames: []string{Cabforumname}, Levels: []string{reserved.Level}}
+				order = append(order, e.OID)
+			}
 		}
-	}()
-	r := bufio.NewReader(fi) // make a read buffer
-	csvr := csv.NewReader(r) // make a CSV reader
-	//  Set any CSV format parameters here if necessary.
-	csvr.TrailingComma = true // allow trailing comma (deprecated)
-	//  Create map for results.  Only save map if success.
-	c.policyOID = make(map[string]Policyinfo) // working info
-	//  Read the file
-	for { // until EOF
-		fields, err := csvr.Read() // read one record
-		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
+		o := byoid[e.OID]
+		if o == nil {
+			o = &Oidconflict{OID: e.OID}
+			byoid[e.OID] = o
+			order = append(order, e.OID)
+		}
+		if len(o.Sources) > 0 && (o.CAnames[0] != e.CAname || o.Levels[0] != e.Level) {
+			o.Conflict = true
+		}
+		o.Sources = append(o.Sources, e.Source)
+		o.CAnames = append(o.CAnames, e.CAname)
+		o.Levels = append(o.Levels, e.Level)
+	}
+	var conflicts []Oidconflict
+	for _, oid := range order {
+		if o := byoid[oid]; len(o.Sources) > 1 {
+			conflicts = append(conflicts, *o)
+		}
+	}
+	return conflicts
+}
+
+//
+//  Readoidfile -- read OID table entries from either format
+//
+//  The CSV format may also have cells which were not understood.
+//
+func Readoidfile(infilename string) ([]Policyentry, []Oidcellproblem, error) {
+	if Isoidtable(infilename) {
+		entries, err := Readoidtable(infilename)
+		return entries, nil, err
+	}
+	return Readoidcsv(infilename)
+}
+
+//
+//  Loadoidinfo -- load policy info from OID table file
+//
+//  Takes either the structured OID table (".json"), or the original
+//  CSV file of certification authorities.
+//  CSV format is: CA name, DV OIDs, OV OIDs, EV OIDs, CPS URL, Notes
+//
+//  CSV OID fields may contain multiple OIDs, and non-OID text, which is
+//  ignored here. Readoidcsv reports it.
+//
+//  Returns any OIDs listed more than once. If strict, conflicting
+//  listings are an error; otherwise the last listing wins. CA/B Forum
+//  reserved OIDs are added, replacing any table listing.
+//
+func (c *CApolicyinfo) Loadoidinfo(infilename string, strict bool) ([]Oidconflict, error) {
+	entries, _, err := Readoidfile(infilename)
+	if err != nil {
+		c.policyOID = nil
+		return nil, err
+	}
+	conflicts := Findoidconflicts(entries)
+	if strict {
+		for i := range conflicts {
+			if conflicts[i].Conflict {
 				c.policyOID = nil
-				return err
+				return conflicts, fmt.Errorf("OID file %s: %s", infilename, conflicts[i])
 			}
 		}
-		c.loadline(fields) // load one line from file
 	}
+	//  Create map for results.  Only save map if success.
+	c.policyOID = make(map[string]Policyinfo) // working info
+	c.addentries(entries)
 	if len(c.policyOID) < 1 { // did not find any domains
-		c.policyOID = nil                                                       // no map
-		return errors.New("No CA policy OIDs found in OID file: " + infilename) // must be bogus file
+		c.policyOID = nil                                                                  // no map
+		return conflicts, errors.New("No CA policy OIDs found in OID file: " + infilename) // must be bogus file
 	}
-	return nil // normal return
+	c.addcabforum()       // known whatever the table says
+	return conflicts, nil // normal return
 }
 
 //
@@ -141,6 +256,49 @@ func (c *CApolicyinfo) Getpolicy(oid string) (Policyinfo, bool) {
 	return v, ok
 }
 
+//
+//  Setenterprisenumbers -- use enterprise number registry to name unknown OIDs
+//
+func (c *CApolicyinfo) Setenterprisenumbers(pens *Enterprisenumbers) {
+	c.pens = pens
+}
+
+//
+//  Oidorganization -- CA of OID from the OID table, else registered
+//  organization of its enterprise arc
+//
+func (c *CApolicyinfo) Oidorganization(oid string) (string, bool) {
+	if v, ok := c.policyOID[oid]; ok {
+		return v.CAname, true
+	}
+	return c.pens.Organization(oid)
+}
+
+//
+//  Addenterpriseoids -- note OIDs not in table, named from the registry, for capolicies
+//
+//  These have no level, so Getpolicy does not return them. They are
+//  only so capolicies has a CA name for every OID it can. Returns the
+//  number added.
+//
+func (c *CApolicyinfo) Addenterpriseoids(oids []string) int {
+	if c.enterpriseOID == nil {
+		c.enterpriseOID = make(map[string]Policyinfo)
+	}
+	added := 0
+	for _, oid := range oids {
+		if _, ok := c.policyOID[oid]; ok {
+			continue
+		}
+		if org, ok := c.pens.Organization(oid); ok {
+			c.enterpriseOID[oid] = Policyinfo{Purpose: PurposeTLS, CAname: org,
+				Source: "IANA enterprise number " + Enterprisearc(oid)[len(enterprisearcprefix):]}
+			added++
+		}
+	}
+	return added
+}
+
 //
 //  Dump -- dump for debug
 //
@@ -151,7 +309,7 @@ func (c *CApolicyinfo) Dump() {
 		return
 	}
 	for k, v := range c.policyOID { // read out map
-		fmt.Printf("  Policy: %s.  OID: '%s'  CA name: %s\n", v.Policy, k, v.CAname)
+		fmt.Printf("  Policy: %s %s.  OID: '%s'  CA name: %s  (%s)\n", v.Purpose, v.Policy, k, v.CAname, v.Source)
 	}
 	fmt.Println("")
 }
@@ -167,7 +325,9 @@ const RECMAX = 10000 // after this many, load the database
 //
 //  Parameters for LOAD DATA INFILE LOCAL for the three tables
 //
-var OLOADPARAMS = "INTO TABLE capolicies"
+//  REPLACE, so a row for an OID already in capolicies is updated.
+//
+var OLOADPARAMS = "REPLACE INTO TABLE capolicies"
 
 //
 //  InsertOIDs -- insert OID records into database
@@ -175,21 +335,47 @@ var OLOADPARAMS = "INTO TABLE capolicies"
 //  Input here is a map.
 //  There aren't really enough to require LOAD DATA INFILE, but the
 //  certs are done that way, so we do this that way.
+//  Rows for OIDs already in capolicies are replaced, not duplicated.
+//  OIDs named from the enterprise number registry are inserted too;
+//  those are only known after the certs are read, so this is done
+//  again then. oloader is a SQLdataloader for MySQL, or another
+//  Tableloader for other databases; it is opened here.
 //
-func InsertOIDs(dbcon *sql.DB, c *CApolicyinfo, verbose bool) error {
+func InsertOIDs(dbcon *sql.DB, oloader Tableloader, c *CApolicyinfo, verbose bool) error {
 	if verbose {
 		fmt.Printf("Loading CA OID list for DV/OV/EV distinction.\n")
 	}
-	var oloader SQLdataloader // the data loader
+	err := insertpolicies(dbcon, oloader, c.policyOID, verbose)
+	if err != nil {
+		return err
+	}
+	if verbose && len(c.enterpriseOID) > 0 {
+		fmt.Printf("Loading %d OIDs named from enterprise number registry.\n", len(c.enterpriseOID))
+	}
+	return insertpolicies(dbcon, oloader, c.enterpriseOID, verbose)
+}
+
+//
+//  insertpolicies -- insert policy OIDs into capolicies
+//
+func insertpolicies(dbcon *sql.DB, oloader Tableloader, policies map[string]Policyinfo, verbose bool) error {
 	oloader.Open(OLOADPARAMS, dbcon, RECMAX, verbose)
 	defer func() { // make sure everything closes, even if fail
 		_ = oloader.Close()
 	}()
-	for k, v := range c.policyOID { // range over the OIDs
-		var fields [3]string       // three fields
+	for k, v := range policies { // range over the OIDs
+		var fields [8]string       // eight fields
 		fields[0] = ToSQLstring(k) // OID
 		fields[1] = ToSQLstring(v.CAname)
-		fields[2] = v.Policy // enum - do we quote enums?
+		fields[2] = ToSQLstring(v.Policy) // enum, NONE for purposes with no validation
+		fields[3] = ToSQLstring(v.CPS)
+		fields[4] = ToSQLstring(v.Notes)
+		fields[5] = ToSQLstring(v.Source)
+		fields[6] = ToSQLstring(v.Purpose)
+		fields[7] = ToSQLstring("") // Loaded_as; NULL, so an edit kept by a merge stays an edit
+		if !v.Edited {
+			fields[7] = ToSQLstring(describepolicy(v.CAname, v.Policy, v.Purpose))
+		}
 		if verbose {
 			fmt.Printf(" Loaded OID %s from %s (%s)\n", fields[0], fields[1], fields[2])
 		}
@@ -0,0 +1,196 @@
+//
+//  classify.go -- effective validation level of a cert from all its policy OIDs
+//
+//  A cert may carry a CA-specific OID and a CA/B Forum generic one, or
+//  OIDs of some CA other than its issuer. Classify decides what the cert
+//  "is", and notes anything odd for a person to look at.
+//
+package util
+
+import "fmt"
+import "net/url"
+import "sort"
+import "strings"
+
+//
+//  Policycert -- what Classify needs from a cert
+//
+//  Implemented by certumich.Processedcert; util can't import certumich.
+//
+type Policycert interface {
+	Policyoids() []string          // policy OIDs of cert
+	Issuerorg() string             // O of issuer, or CN if no O
+	Policycps(oid string) []string // CPS URIs the cert gives for a policy OID
+}
+
+//
+//  Classification -- effective validation level of a cert
+//
+type Classification struct {
+	Level     string   // "DV", "OV", "IV", or "EV"; "" if no known TLS OID
+	OIDs      []string // OIDs which decided the level
+	Anomalies []string // OIDs of another CA, contradictory levels, etc.
+	Owners    []string // CA or registered organization of each policy OID, "" if not known
+}
+
+//
+//  policylevelrank -- position of level in Policylevels, -1 if none
+//
+func policylevelrank(level string) int {
+	for i, l := range Policylevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+//
+//  Words in CA names which say nothing about which CA it is.
+//  "Network Solutions" and "USERTrust Network" are different CAs.
+//
+var castopwords = map[string]bool{
+	"trust": true, "global": true, "secure": true, "network": true, "networks": true, "security": true,
+	"certificate": true, "certificates": true, "certification": true, "authority": true, "services": true,
+	"root": true, "digital": true, "internet": true, "server": true, "validation": true, "extended": true,
+	"organization": true, "domain": true, "class": true, "public": true, "primary": true,
+	"intermediate": true, "issuing": true, "solutions": true, "systems": true,
+}
+
+//
+//  issuerhasca -- true if issuer name goes with CA name of an OID
+//
+//  "COMODO CA Limited" goes with "Comodo CA Ltd". Whole words must
+//  match, and generic CA words don't count, except that a CA name run
+//  together, "Go Daddy" as "godaddy", goes with "GoDaddy.com, Inc.",
+//  and a CA named only in generic words, "Network Solutions", needs
+//  all of them. CAs are bought and renamed, so a mismatch is an anomaly
+//  to look at, not an error.
+//
+func issuerhasca(issuer string, caname string) bool {
+	issuertokens := make(map[string]bool)
+	var issuerjoined, cajoined string
+	for _, t := range orgtokens(issuer) {
+		issuertokens[t] = true
+		if !castopwords[t] {
+			issuerjoined += t
+		}
+	}
+	catokens := orgtokens(caname)
+	for _, t := range catokens {
+		if castopwords[t] {
+			continue
+		}
+		if len(t) >= minsharedtoken && issuertokens[t] {
+			return true
+		}
+		cajoined += t
+	}
+	if cajoined == "" { // only generic words
+		for _, t := range catokens {
+			if !issuertokens[t] {
+				return false
+			}
+		}
+		return len(catokens) > 0
+	}
+	return len(cajoined) >= minsharedtoken && strings.Contains(issuerjoined, cajoined)
+}
+
+//
+//  cpshost -- host of a CPS URL, lower case, without "www."
+//
+func cpshost(cps string) string {
+	u, err := url.Parse(strings.TrimSpace(cps))
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+//
+//  cpsmatches -- true if one of a cert's CPS URIs is at the CPS site in the OID table
+//
+//  CAs move documents around on their own site, so only the host is
+//  compared, and a subdomain of the table's host is the same site.
+//  "http://repository.digicert.com/cps" goes with "https://www.digicert.com/legal-repository".
+//
+func cpsmatches(tablecps string, certcps []string) bool {
+	want := cpshost(tablecps)
+	if want == "" {
+		return true // nothing to check against
+	}
+	for _, cps := range certcps {
+		got := cpshost(cps)
+		if got == want || strings.HasSuffix(got, "."+want) { // never a parent, which could be a bare "com"
+			return true
+		}
+	}
+	return false
+}
+
+//
+//  Classify -- effective validation level of a cert from all its policy OIDs
+//
+//  CA-specific OIDs decide if there are any, since the CA says more
+//  about what it checked than the generic OIDs do; otherwise the CA/B
+//  Forum OIDs decide. If the deciding OIDs disagree, the lowest level
+//  wins, since the CA claimed at least that much.
+//
+func (c *CApolicyinfo) Classify(cert Policycert) Classification {
+	var result Classification
+	issuer := cert.Issuerorg()
+	var specific, generic []string // known TLS OIDs
+	for _, oid := range cert.Policyoids() {
+		owner, _ := c.Oidorganization(oid)
+		result.Owners = append(result.Owners, owner)
+		p, ok := c.Getpolicy(oid)
+		if !ok {
+			continue
+		}
+		if p.Purpose != PurposeTLS {
+			result.Anomalies = append(result.Anomalies, fmt.Sprintf("OID %s is for %s", oid, p.Purpose))
+			continue
+		}
+		if Iscabforumoid(oid) {
+			generic = append(generic, oid)
+			continue
+		}
+		specific = append(specific, oid)
+		if issuer != "" && !issuerhasca(issuer, p.CAname) {
+			result.Anomalies = append(result.Anomalies, fmt.Sprintf("OID %s belongs to '%s', not issuer '%s'", oid, p.CAname, issuer))
+		}
+		if certcps := cert.Policycps(oid); len(certcps) > 0 && !cpsmatches(p.CPS, certcps) {
+			result.Anomalies = append(result.Anomalies, fmt.Sprintf("OID %s CPS '%s' is not at '%s'", oid, strings.Join(certcps, " "), p.CPS))
+		}
+	}
+	deciding := specific
+	if len(deciding) == 0 {
+		deciding = generic
+	}
+	levels := make(map[string]bool)
+	for _, oid := range deciding {
+		p, _ := c.Getpolicy(oid)
+		levels[p.Policy] = true
+		if result.Level == "" || policylevelrank(p.Policy) < policylevelrank(result.Level) {
+			result.Level = p.Policy
+		}
+	}
+	result.OIDs = deciding
+	//  Generic OIDs which disagree with the specific ones are contradictions too.
+	if len(specific) > 0 {
+		for _, oid := range generic {
+			p, _ := c.Getpolicy(oid)
+			levels[p.Policy] = true
+		}
+	}
+	if len(levels) > 1 {
+		names := make([]string, 0, len(levels))
+		for level := range levels {
+			names = append(names, level)
+		}
+		sort.Slice(names, func(i, j int) bool { return policylevelrank(names[i]) < policylevelrank(names[j]) })
+		result.Anomalies = append(result.Anomalies, "contradictory levels "+strings.Join(names, ", "))
+	}
+	return result
+}
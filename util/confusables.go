@@ -0,0 +1,159 @@
+//
+//  confusables.go -- IDN homograph detection by confusable skeletons
+//
+//  Two names are confusable if they have the same skeleton, per
+//  Unicode TR39 section 4: "раураl.com", in Cyrillic, and "paypal.com"
+//  both have the skeleton "paypal.com".
+//
+package util
+
+import "os"
+import "io"
+import "bufio"
+import "fmt"
+import "strings"
+import "strconv"
+import "errors"
+import "unicode"
+import "golang.org/x/text/unicode/norm"
+
+//
+//  Confusables -- table of confusable characters, from confusables.txt
+//
+type Confusables struct {
+	prototypes map[rune]string // character -> prototype string
+}
+
+//
+//  Loadconfusables -- load the confusables table
+//
+//  Format is that of the Unicode Consortium's confusables.txt:
+//
+//    0441 ;	0063 ;	MA	# ( с → c ) CYRILLIC SMALL LETTER ES → LATIN SMALL LETTER C
+//
+func (c *Confusables) Loadconfusables(infile string) error {
+	fi, err := os.Open(infile) // open input file
+	if err != nil {
+		return err
+	}
+	defer func() { // handle close
+		if err := fi.Close(); err != nil {
+			panic(err) // failed close is legit panic
+		}
+	}()
+	c.prototypes = make(map[rune]string) // working info
+	r := bufio.NewReader(fi)             // make a read buffer
+	for {                                // until EOF
+		s, err := r.ReadString('\n') // read a line
+		if err != nil && err != io.EOF {
+			c.prototypes = nil
+			return err // I/O error
+		}
+		if i := strings.Index(s, "#"); i >= 0 { // drop comment
+			s = s[:i]
+		}
+		s = strings.TrimPrefix(s, "\ufeff") // drop byte order mark
+		fields := strings.Split(s, ";")
+		if len(fields) >= 2 { // source ; prototype ; type
+			source, ok1 := parsecodepoints(fields[0])
+			prototype, ok2 := parsecodepoints(fields[1])
+			if !ok1 || !ok2 || len([]rune(source)) != 1 {
+				c.prototypes = nil
+				return fmt.Errorf("Bad line in confusables file %s: '%s'", infile, strings.TrimSpace(s))
+			}
+			c.prototypes[[]rune(source)[0]] = prototype
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(c.prototypes) < 1 { // did not find any
+		c.prototypes = nil
+		return errors.New("No confusable characters found in confusables file: " + infile)
+	}
+	return nil
+}
+
+//
+//  parsecodepoints -- "0072 006E" to "rn"
+//
+func parsecodepoints(s string) (string, bool) {
+	items := strings.Fields(s)
+	if len(items) == 0 {
+		return "", false
+	}
+	runes := make([]rune, 0, len(items))
+	for i := range items {
+		n, err := strconv.ParseUint(items[i], 16, 32)
+		if err != nil {
+			return "", false
+		}
+		runes = append(runes, rune(n))
+	}
+	return string(runes), true
+}
+
+//
+//  Skeleton -- TR39 skeleton of a string
+//
+//  NFD, replace each character with its prototype, NFD again. Then
+//  lower case, because domain names are case-insensitive but some
+//  prototypes are upper case ("0" -> "O").
+//
+func (c *Confusables) Skeleton(s string) string {
+	if c.prototypes == nil {
+		panic("Confusables not loaded")
+	}
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if p, ok := c.prototypes[r]; ok {
+			b.WriteString(p)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return strings.ToLower(norm.NFD.String(b.String()))
+}
+
+//
+//  Mixedscript -- true if any label of a name has letters from more than one script
+//
+//  "раураl" (Cyrillic with a Latin "l") is mixed script; "пример" is not.
+//
+func Mixedscript(name string) bool {
+	labels := strings.Split(name, ".")
+	for i := range labels {
+		var first *unicode.RangeTable // script of first letter in label
+		seen := false                 // any letter yet?
+		for _, r := range labels[i] {
+			if !unicode.IsLetter(r) {
+				continue
+			}
+			script := scriptof(r)
+			if !seen {
+				first = script
+				seen = true
+			} else if script != first {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//
+//  Scripts which matter for homographs. Anything else counts as "other".
+//
+var homographscripts = []*unicode.RangeTable{unicode.Latin, unicode.Cyrillic, unicode.Greek, unicode.Armenian}
+
+//
+//  scriptof -- script of a letter
+//
+func scriptof(r rune) *unicode.RangeTable {
+	for _, script := range homographscripts {
+		if unicode.Is(script, r) {
+			return script
+		}
+	}
+	return nil // other
+}
@@ -15,7 +15,9 @@ import "io"
 import "bufio"
 import "regexp"
 import "errors"
-import "code.google.com/p/go.net/idna"
+import "crypto/sha256"
+import "encoding/hex"
+import "golang.org/x/net/idna"
 
 //
 //  Issubdomain  -- true if a is subdomain of b.
@@ -37,8 +39,89 @@ func Issubdomain(a string, b string) bool {
 //
 //  DomainSuffixes -- public domain name suffixes and lookup utilities for them
 //
+//  Implements the Public Suffix List algorithm from
+//  "https://publicsuffix.org/list/", including wildcard ("*.ck") and
+//  exception ("!www.ck") rules, and the implicit "*" default rule.
+//
+//  Rules are kept in a trie of labels, rightmost label first, so a lookup
+//  is one map probe per label with no allocation. This is called for
+//  every domain of every cert.
+//
 type DomainSuffixes struct {
-	reversedsuffixes map[string]bool // suffixes, reversed. Set.
+	Info  Suffixlistinfo // which list was loaded
+	root  *suffixnode    // "" - the root of all TLDs
+	count int            // number of rules loaded
+}
+
+//
+//  Suffixlistinfo -- identifies the suffix list loaded
+//
+//  The 2LD of a domain depends on the list, so this is recorded with each
+//  run. Version and Commit come from the list's header comments, and are
+//  empty for older lists without them.
+//
+type Suffixlistinfo struct {
+	File    string // file name as given
+	Version string // "VERSION:" header, "2024-05-31_09-26-35_UTC"
+	Commit  string // "COMMIT:" header, git commit of list
+	Hash    string // SHA-256 of file contents, hex
+	Private bool   // PRIVATE section loaded
+}
+
+//
+//  suffixnode -- one label in the trie of rules
+//
+//  The node for "co" under "uk" represents "co.uk".
+//
+type suffixnode struct {
+	children map[string]*suffixnode // next label to the left
+	flags    int                    // rules for the suffix ending here
+}
+
+//
+//  Domainoffsets -- where the parts of a domain name start, as byte offsets into it
+//
+//  For "sub.example.co.uk", Registrable is 4 ("example.co.uk") and Suffix
+//  is 12 ("co.uk"). The subdomain, if any, is name[:Registrable-1].
+//
+type Domainoffsets struct {
+	Registrable int           // start of registrable domain, -1 if name is a public suffix
+	Suffix      int           // start of public suffix
+	Section     Suffixsection // list section of suffix, Sectionnone if by default rule
+}
+
+//
+//  Rule flags. One suffix can have more than one kind of rule.
+//
+const (
+	rulenormal    = 1 << iota // "ck" - this is a public suffix
+	rulewildcard              // "*.ck" - any label under this is a public suffix
+	ruleexception             // "!www.ck" - this is not a public suffix, its parent is
+	ruleprivate               // rule came from the PRIVATE section
+)
+
+//
+//  Suffixsection -- which section of the list a public suffix came from
+//
+type Suffixsection int
+
+const (
+	Sectionnone    Suffixsection = iota // not listed; default "*" rule
+	Sectionicann                        // ICANN DOMAINS section
+	Sectionprivate                      // PRIVATE DOMAINS section, e.g. "blogspot.com"
+)
+
+//
+//  String -- section name, as used in the list and the domains table
+//
+func (s Suffixsection) String() string {
+	switch s {
+	case Sectionicann:
+		return "ICANN"
+	case Sectionprivate:
+		return "PRIVATE"
+	}
+	return ""
 }
 
 //
@@ -64,32 +147,152 @@ func (d *DomainSuffixes) Samesecondleveldomain(a string, b string) (bool, bool)
 	return (atld == btld) && (a2nd == b2nd), aok && bok // return true if matched
 }
 
+//
+//  Suffixoffsets  -- find the public suffix and registrable domain of a name
+//
+//  Longest matching rule wins, except that an exception rule prevails.
+//  If no listed rule matches, the default "*" rule applies, making the
+//  last label the public suffix, and the section is Sectionnone.
+//  Matching is exact; callers normalize case and punycode.
+//  Does not allocate.
+//
+func (d *DomainSuffixes) Suffixoffsets(s string) Domainoffsets {
+	if d.root == nil {
+		panic("DomainSuffixes not loaded")
+	}
+	node := d.root
+	suffix := -1           // start of longest match so far
+	section := Sectionnone // and its section
+	end := len(s)          // end of label being examined
+	lastlabel := -1        // start of last label, for default rule
+	for {                  // labels right to left
+		start := strings.LastIndexByte(s[:end], '.') + 1 // start of this label
+		if lastlabel < 0 {
+			lastlabel = start
+		}
+		child := node.children[s[start:end]]
+		if child != nil && child.flags&ruleexception != 0 { // exception rules prevail
+			return d.registrable(s, end+1, rulesection(child.flags))
+		}
+		if child != nil && child.flags&rulenormal != 0 {
+			suffix = start
+			section = rulesection(child.flags)
+		} else if node.flags&rulewildcard != 0 { // wildcard matches any one label
+			suffix = start
+			section = rulesection(node.flags)
+		}
+		if child == nil || start == 0 { // can go no further
+			break
+		}
+		node = child
+		end = start - 1
+	}
+	if suffix < 0 {
+		return d.registrable(s, lastlabel, Sectionnone) // default rule "*"
+	}
+	return d.registrable(s, suffix, section)
+}
+
+//
+//  registrable -- fill in Domainoffsets given the start of the public suffix
+//
+func (d *DomainSuffixes) registrable(s string, suffix int, section Suffixsection) Domainoffsets {
+	reg := -1 // no registrable domain if name is all suffix
+	if suffix > 0 {
+		reg = strings.LastIndexByte(s[:suffix-1], '.') + 1 // one more label
+	}
+	return Domainoffsets{Registrable: reg, Suffix: suffix, Section: section}
+}
+
+//
+//  rulesection -- section of list for a rule
+//
+func rulesection(flags int) Suffixsection {
+	if flags&ruleprivate != 0 {
+		return Sectionprivate
+	}
+	return Sectionicann
+}
+
 //
 //  Domainparts  -- break up domain name into public TLD, 2nd level domain, and local subdomain
 //
 //  "sub.example.com" is broken into local subdomain "sub", 2nd level domain "example", and TLD "com"
 //
-//  The list of public TLDs is used for this purpose.
+//  The list of public TLDs is used for this purpose. Names which match
+//  only the default "*" rule, i.e. with a TLD not in the list, are rejected.
 //
 func (d *DomainSuffixes) Domainparts(s string) (string, string, string, bool) {
-	if d.reversedsuffixes == nil {
-		panic("DomainSuffixes not loaded")
+	sub, second, tld, _, ok := d.Domaininfo(s)
+	return sub, second, tld, ok
+}
+
+//
+//  Domaininfo  -- Domainparts, plus the section of the list the TLD came from
+//
+func (d *DomainSuffixes) Domaininfo(s string) (string, string, string, Suffixsection, bool) {
+	offs := d.Suffixoffsets(s)
+	if offs.Section == Sectionnone { // no TLD match
+		return "", "", "", offs.Section, false
 	}
-	parts := strings.Split(s, ".")    // domain parts
-	for i := 0; i < len(parts); i++ { // finding longest TLD that matches
-		tld := strings.Join(parts[i:], ".")         // candidate TLD
-		if d.reversedsuffixes[Reversedomain(tld)] { // if matched TLD
-			switch {
-			case i == 0:
-				return "", "", tld, false // this is a TLD - it has no 2nd
-			case i == 1:
-				return "", parts[0], tld, true // second level domain
-			default:
-				return strings.Join(parts[0:i-1], "."), parts[i-1], tld, true // sub, 2nd, tld, OK
+	tld := s[offs.Suffix:]
+	switch {
+	case offs.Registrable < 0:
+		return "", "", tld, offs.Section, false // this is a TLD - it has no 2nd
+	case offs.Registrable == 0:
+		return "", s[:offs.Suffix-1], tld, offs.Section, true // second level domain
+	default:
+		return s[:offs.Registrable-1], s[offs.Registrable : offs.Suffix-1], tld, offs.Section, true // sub, 2nd, tld, OK
+	}
+}
+
+//
+//  Registrabledomain -- the registrable domain (public suffix plus one label) of a name
+//
+//  Follows the Public Suffix List algorithm exactly, including the default
+//  rule, case folding, and punycode input. Returns "", false for names
+//  which are themselves public suffixes, or are not valid names.
+//
+func (d *DomainSuffixes) Registrabledomain(s string) (string, bool) {
+	s = strings.ToLower(s)
+	u, err := idna.ToUnicode(s) // the list is all Unicode
+	if err != nil {
+		return "", false
+	}
+	if strings.HasPrefix(u, ".") || strings.HasSuffix(u, ".") || strings.Contains(u, "..") || u == "" {
+		return "", false // empty label
+	}
+	offs := d.Suffixoffsets(u)
+	if offs.Registrable < 0 { // name is a public suffix
+		return "", false
+	}
+	labels := strings.Count(u[offs.Registrable:], ".") + 1 // same labels, as given
+	orig := strings.Split(s, ".")
+	return strings.Join(orig[len(orig)-labels:], "."), true
+}
+
+//
+//  addrule -- add one rule to the trie
+//
+func (d *DomainSuffixes) addrule(rule string, flag int) {
+	node := d.root
+	end := len(rule)
+	for end >= 0 { // labels right to left
+		start := strings.LastIndexByte(rule[:end], '.') + 1
+		label := rule[start:end]
+		child := node.children[label]
+		if child == nil {
+			child = &suffixnode{}
+			if node.children == nil {
+				node.children = make(map[string]*suffixnode)
 			}
+			node.children[label] = child
 		}
+		node = child
+		end = start - 1
 	}
-	return "", "", "", false // no TLD match
+	node.flags |= flag
+	d.count++
 }
 
 //
@@ -97,9 +300,14 @@ func (d *DomainSuffixes) Domainparts(s string) (string, string, string, bool) {
 //
 //  This comes from "https://publicsuffix.org/list/effective_tld_names.dat"
 //
-func (d *DomainSuffixes) Loadpublicsuffixlist(infile string) error {
+//  Only the ICANN section is loaded unless includeprivate. The PRIVATE
+//  section has shared hosting suffixes such as "blogspot.com".
+//
+func (d *DomainSuffixes) Loadpublicsuffixlist(infile string, includeprivate bool) error {
 	const icannstart = "===BEGIN ICANN DOMAINS==="
 	const icannend = "===END ICANN DOMAINS==="
+	const privatestart = "===BEGIN PRIVATE DOMAINS==="
+	const privateend = "===END PRIVATE DOMAINS==="
 	redelim := regexp.MustCompile(`===.+===`) // get section delimiter, of form "===DELIM==="
 	//  Read the file
 	fi, err := os.Open(infile) // open file of public domain suffixes
@@ -111,10 +319,14 @@ func (d *DomainSuffixes) Loadpublicsuffixlist(infile string) error {
 			panic(err) // failed close is legit panic
 		}
 	}()
-	d.reversedsuffixes = make(map[string]bool) // suffix set - value always true
-	r := bufio.NewReader(fi)                   // make a read buffer
-	inicann := false                           // not in ICANN block yet
-	for {                                      // until EOF
+	d.root = &suffixnode{} // suffix rules
+	d.count = 0            // none yet
+	d.Info = Suffixlistinfo{File: infile, Private: includeprivate}
+	hasher := sha256.New()                         // hash of entire file, as read
+	r := bufio.NewReader(io.TeeReader(fi, hasher)) // make a read buffer
+	inicann := false                               // not in ICANN block yet
+	inprivate := false                             // not in PRIVATE block yet
+	for {                                          // until EOF
 		s, err := r.ReadString('\n') // read a line
 		if err != nil {              // EOF or error
 			if err == io.EOF { // if EOF
@@ -127,6 +339,13 @@ func (d *DomainSuffixes) Loadpublicsuffixlist(infile string) error {
 			continue
 		}
 		if strings.HasPrefix(s, "//") { // if comment, which includes section delim
+			header := strings.TrimSpace(s[2:])
+			switch {
+			case strings.HasPrefix(header, "VERSION:") && d.Info.Version == "": // first one only
+				d.Info.Version = strings.TrimSpace(header[len("VERSION:"):])
+			case strings.HasPrefix(header, "COMMIT:") && d.Info.Commit == "":
+				d.Info.Commit = strings.TrimSpace(header[len("COMMIT:"):])
+			}
 			found := redelim.Find([]byte(s)) // matches "===ANYTHING==="
 			if len(found) > 0 {              // if found something
 				delim := string(found[:]) // delimiter as string
@@ -137,36 +356,95 @@ func (d *DomainSuffixes) Loadpublicsuffixlist(infile string) error {
 				case delim == icannend: // if leaving ICANN block
 					inicann = false
 					break
+				case delim == privatestart: // if entering PRIVATE block
+					inprivate = true
+					break
+				case delim == privateend: // if leaving PRIVATE block
+					inprivate = false
+					break
 				}
 			}
 		} else { // non-comment
-			if inicann { // save ICANN names only
-				//  ***SHOULD VALIDATE DOMAIN SYNTAX HERE***
-				domain, err := idna.ToUnicode(s) // all Unicode, no punycode
+			if inicann || (inprivate && includeprivate) { // save ICANN names, and PRIVATE if asked
+				rule := strings.Fields(s)[0] // rule ends at first whitespace
+				flag := rulenormal
+				switch {
+				case strings.HasPrefix(rule, "!"):
+					flag = ruleexception
+					rule = rule[1:]
+				case strings.HasPrefix(rule, "*."):
+					flag = rulewildcard
+					rule = rule[2:]
+				}
+				domain, err := idna.ToUnicode(rule) // all Unicode, no punycode
 				if err != nil {
 					return err
 				}
-				d.reversedsuffixes[Reversedomain(domain)] = true // add to domain suffixes
+				if status := Checkhostsyntax(domain); status != Hostok { // must be a bogus file
+					return fmt.Errorf("Bad rule '%s' in suffix file %s: %s", s, infile, status)
+				}
+				if inprivate {
+					flag |= ruleprivate
+				}
+				d.addrule(domain, flag) // add to domain suffixes
 			}
 		}
 	}
 	// finish up
-	if len(d.reversedsuffixes) < 1 { // did not find any domains
-		d.reversedsuffixes = nil                                          // no map
+	d.Info.Hash = hex.EncodeToString(hasher.Sum(nil))
+	if d.count < 1 { // did not find any domains
+		d.root = nil                                                      // no rules
 		return errors.New("No domain suffixes in suffix file: " + infile) // must be bogus file
 	}
 	return nil // normal return
 }
 
+//
+//  String -- list identification, for messages
+//
+func (i Suffixlistinfo) String() string {
+	version := i.Version
+	if version == "" {
+		version = "unversioned"
+	}
+	s := fmt.Sprintf("Suffix list '%s' (%s, SHA-256 %s)", i.File, version, i.Hash)
+	if i.Private {
+		s += " with PRIVATE section"
+	}
+	return s
+}
+
 //
 //  Dump -- dump state of this object for debug
 //
 func (d *DomainSuffixes) Dump() {
-	fmt.Printf("Domain suffixes. Loaded=%t.\n", d.reversedsuffixes != nil) // dump to standard output
-	if d.reversedsuffixes != nil {
-		fmt.Printf(" %d domain suffixes:\n", len(d.reversedsuffixes))
-		for key, _ := range d.reversedsuffixes {
-			fmt.Printf("  '%s'\n", key)
+	fmt.Printf("Domain suffixes. Loaded=%t.\n", d.root != nil) // dump to standard output
+	fmt.Printf(" %s\n", d.Info)
+	if d.root != nil {
+		fmt.Printf(" %d domain suffixes:\n", d.count)
+		d.root.dump("")
+	}
+}
+
+//
+//  dump -- dump rules at and below this trie node
+//
+func (n *suffixnode) dump(suffix string) {
+	section := rulesection(n.flags)
+	if n.flags&rulenormal != 0 {
+		fmt.Printf("  '%s' (%s)\n", suffix, section)
+	}
+	if n.flags&rulewildcard != 0 {
+		fmt.Printf("  '*.%s' (%s)\n", suffix, section)
+	}
+	if n.flags&ruleexception != 0 {
+		fmt.Printf("  '!%s' (%s)\n", suffix, section)
+	}
+	for label, child := range n.children {
+		if suffix == "" {
+			child.dump(label)
+		} else {
+			child.dump(label + "." + suffix)
 		}
 	}
 }
@@ -0,0 +1,93 @@
+//
+//  homographs.go -- find domains which look like other domains
+//
+package util
+
+import "strings"
+
+//
+//  Homographmatch -- a domain which looks like another one
+//
+type Homographmatch struct {
+	Domain      string // the lookalike 2LD
+	Lookalike   string // what it looks like
+	Brand       bool   // Lookalike is on the brand list, not just seen in the data
+	Mixedscript bool   // Domain mixes scripts within a label
+}
+
+//
+//  Homographs -- homograph detector
+//
+//  Keeps the skeleton of each 2LD it is asked about, up to
+//  homographmaxseen of them, so memory use stays bounded on a long load.
+//
+type Homographs struct {
+	conf    *Confusables      // confusable characters
+	brands  map[string]string // brand skeleton -> brand domain
+	seen    map[string]string // skeleton -> first 2LD seen with it
+	maxseen int               // most skeletons kept in seen
+}
+
+//
+//  Most 2LD skeletons kept for comparing within the dataset. Past this,
+//  later 2LDs are still checked against the ones kept, and the brands.
+//
+const homographmaxseen = 1000000
+
+//
+//  Init -- set up detector with confusables table and brand list
+//
+//  brands may be nil, to compare only within the dataset.
+//
+func (h *Homographs) Init(conf *Confusables, brands *Brandlist) {
+	h.conf = conf
+	h.brands = make(map[string]string)
+	h.seen = make(map[string]string)
+	h.maxseen = homographmaxseen
+	if brands != nil {
+		for i := range brands.Brands {
+			h.brands[conf.Skeleton(brands.Brands[i].Domain)] = brands.Brands[i].Domain
+		}
+	}
+}
+
+//
+//  isidn -- true if a domain has any internationalized label
+//
+func isidn(domain string) bool {
+	return !isascii(domain) || strings.HasPrefix(domain, "xn--") || strings.Contains(domain, ".xn--")
+}
+
+//
+//  Check -- check a 2LD against the brand list and the 2LDs seen so far
+//
+//  Returns true if the 2LD has the same skeleton as a different brand
+//  domain, or as a previously seen 2LD when one of the two is an IDN.
+//  Two ASCII names such as "rn" and "m" look alike only in some fonts,
+//  and are everywhere, so are only reported against brands. Brand
+//  matches take precedence.
+//
+func (h *Homographs) Check(domain string) (Homographmatch, bool) {
+	if h.conf == nil {
+		panic("Homographs not initialized")
+	}
+	skel := h.conf.Skeleton(domain)
+	match := Homographmatch{Domain: domain, Mixedscript: Mixedscript(domain)}
+	if brand, ok := h.brands[skel]; ok && brand != domain {
+		match.Lookalike = brand
+		match.Brand = true
+		return match, true
+	}
+	other, ok := h.seen[skel]
+	if !ok {
+		if len(h.seen) < h.maxseen {
+			h.seen[skel] = domain // first one with this skeleton
+		}
+		return match, false
+	}
+	if other == domain || (!isidn(domain) && !isidn(other)) {
+		return match, false
+	}
+	match.Lookalike = other
+	return match, true
+}
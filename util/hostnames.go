@@ -0,0 +1,264 @@
+//
+//  hostnames.go -- normalizing host names from certificates
+//
+//  CN and SAN fields contain host names in any case, with trailing
+//  dots and wildcards, and sometimes IP addresses or people's names.
+//  Everything goes through NormalizeHostname before domain analysis.
+//
+package util
+
+import "net"
+import "strings"
+import "unicode"
+import "golang.org/x/net/idna"
+
+//
+//  Hostclass -- what kind of thing a CN or SAN value is
+//
+type Hostclass int
+
+const (
+	Hostname    Hostclass = iota // ordinary host name, "www.example.com"
+	Wildcard                     // wildcard host name, "*.example.com"
+	IPliteral                    // IPv4 or IPv6 address
+	Nothostname                  // anything else, such as a person's name
+)
+
+//
+//  String -- class name, for messages and the database
+//
+func (c Hostclass) String() string {
+	switch c {
+	case Hostname:
+		return "hostname"
+	case Wildcard:
+		return "wildcard"
+	case IPliteral:
+		return "ip"
+	}
+	return "nothostname"
+}
+
+//
+//  Isdomain -- true if this class of name has a domain to analyze
+//
+func (c Hostclass) Isdomain() bool {
+	return c == Hostname || c == Wildcard
+}
+
+//
+//  hostprofile -- IDNA2008 with UTS-46 mapping, as for lookup
+//
+//  STD3 rules are off; underscores and such are common in certs, and
+//  are dealt with by the caller, not by failing the conversion.
+//
+var hostprofile = idna.New(idna.MapForLookup(), idna.Transitional(false), idna.StrictDomainName(false))
+
+//
+//  NormalizeHostname -- canonical form of a host name from a certificate
+//
+//  Applies UTS-46 mapping (which lower cases), converts punycode to
+//  Unicode, and removes surrounding white space, a trailing dot, and
+//  a leading "*." wildcard. IP addresses are returned in canonical form.
+//
+//  Returns the normalized name, its class, and an error only if a
+//  punycode label would not decode. Values which are not host names
+//  at all are returned trimmed, as Nothostname, without error. Names
+//  which are badly formed, but made of host name characters, are
+//  returned lower cased, for Validatehostname to classify.
+//
+func NormalizeHostname(s string) (string, Hostclass, error) {
+	s = strings.TrimSpace(s)
+	if ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")); ip != nil {
+		return ip.String(), IPliteral, nil
+	}
+	s = strings.TrimSuffix(s, ".") // absolute name, same thing
+	class := Hostname
+	if strings.HasPrefix(s, "*.") {
+		s = s[2:]
+		class = Wildcard
+	}
+	if s == "" || strings.IndexFunc(s, nothostchar) >= 0 { // person's name, etc.
+		return s, Nothostname, nil
+	}
+	u, err := hostprofile.ToUnicode(s)
+	if err != nil {
+		if strings.Contains(strings.ToLower(s), "xn--") { // bad punycode
+			return s, Nothostname, err
+		}
+		return strings.ToLower(s), class, nil // not valid IDNA; Validatehostname says why
+	}
+	return u, class, nil
+}
+
+//
+//  nothostchar -- true for characters never found in host names
+//
+//  Letters and digits in any script pass; the IDNA mapping sorts those out.
+//
+func nothostchar(r rune) bool {
+	switch {
+	case r == '.' || r == '-' || r == '_':
+		return false
+	case r == '\u3002' || r == '\uff0e' || r == '\uff61': // dots which IDNA maps to "."
+		return false
+	case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r):
+		return false
+	}
+	return true
+}
+
+//
+//  Hoststatus -- result of checking a normalized CN or SAN value
+//
+//  Names with a status other than Hostok or Hostunderscore are not
+//  used for domain analysis.
+//
+type Hoststatus int
+
+const (
+	Hostok           Hoststatus = iota // good host name with known public suffix
+	Hostunderscore                     // "_" in a label; not a valid host name, but valid DNS, so used
+	Hostipliteral                      // IP address, in CN or SAN
+	Hostnothostname                    // not a host name at all, such as a person's name
+	Hostbadidn                         // punycode label which will not decode
+	Hostemptylabel                     // "a..b", or nothing but a dot
+	Hosttoolong                        // over 253 characters, as punycode
+	Hostlabeltoolong                   // a label over 63 characters, as punycode
+	Hostbadchar                        // characters not allowed in host names
+	Hosthyphen                         // label starting or ending with "-"
+	Hostinternal                       // internal name, such as ".local" or ".corp"
+	Hostnosuffix                       // no known public suffix
+)
+
+//
+//  String -- status name, for messages and the database
+//
+func (s Hoststatus) String() string {
+	switch s {
+	case Hostok:
+		return "OK"
+	case Hostunderscore:
+		return "UNDERSCORE"
+	case Hostipliteral:
+		return "IP"
+	case Hostnothostname:
+		return "NOTHOSTNAME"
+	case Hostbadidn:
+		return "BADIDN"
+	case Hostemptylabel:
+		return "EMPTYLABEL"
+	case Hosttoolong:
+		return "TOOLONG"
+	case Hostlabeltoolong:
+		return "LABELTOOLONG"
+	case Hostbadchar:
+		return "BADCHAR"
+	case Hosthyphen:
+		return "HYPHEN"
+	case Hostinternal:
+		return "INTERNAL"
+	case Hostnosuffix:
+		return "NOSUFFIX"
+	}
+	return "UNKNOWN"
+}
+
+//
+//  Isused -- true if names with this status are used for domain analysis
+//
+func (s Hoststatus) Isused() bool {
+	return s == Hostok || s == Hostunderscore
+}
+
+//
+//  Limits from RFC 1035, in octets of the ASCII (punycode) form
+//
+const maxhostlength = 253 // without trailing dot
+const maxlabellength = 63
+
+//
+//  Internal top level names, used on private networks and never delegated.
+//  CAs issued certs for these until 2015.
+//
+var internaltlds = map[string]bool{
+	"local": true, "localdomain": true, "localhost": true, "lan": true, "home": true,
+	"corp": true, "internal": true, "intranet": true, "private": true,
+	"test": true, "invalid": true,
+}
+
+//
+//  Checkhostsyntax -- check syntax of a normalized host name
+//
+//  Checks lengths and characters of the punycode form. Letters, digits
+//  and "-" are allowed; "_" is allowed but noted. Does not check the
+//  suffix.
+//
+func Checkhostsyntax(name string) Hoststatus {
+	ascii, err := hostprofile.ToASCII(name)
+	if err != nil {
+		if !isascii(name) {
+			return Hostbadchar
+		}
+		ascii = name // IDNA rejects leading hyphens, etc., checked below
+	}
+	if len(ascii) > maxhostlength {
+		return Hosttoolong
+	}
+	status := Hostok
+	for _, label := range strings.Split(ascii, ".") {
+		switch {
+		case len(label) == 0:
+			return Hostemptylabel
+		case len(label) > maxlabellength:
+			return Hostlabeltoolong
+		case label[0] == '-' || label[len(label)-1] == '-':
+			return Hosthyphen
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			switch {
+			case c == '_':
+				status = Hostunderscore
+			case !ishostbyte(c):
+				return Hostbadchar
+			}
+		}
+	}
+	return status
+}
+
+//
+//  Validatehostname -- classify a value from NormalizeHostname
+//
+//  Syntax check plus internal names. Whether the suffix is known is
+//  up to the caller, which has the suffix list.
+//
+func Validatehostname(name string, class Hostclass) Hoststatus {
+	switch class {
+	case IPliteral:
+		return Hostipliteral
+	case Nothostname:
+		return Hostnothostname
+	}
+	status := Checkhostsyntax(name)
+	if !status.Isused() {
+		return status
+	}
+	if internaltlds[name[strings.LastIndexByte(name, '.')+1:]] {
+		return Hostinternal
+	}
+	return status
+}
+
+//
+//  isascii -- true if all ASCII
+//
+func isascii(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
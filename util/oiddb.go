@@ -0,0 +1,173 @@
+//
+//  oiddb.go -- CA policy OIDs from the capolicies table
+//
+//  Analysts fix classifications in the database. Reading them back,
+//  alone or merged with the OID table, keeps the fixes from being lost
+//  on the next run. Each load writes the row as loaded in Loaded_as, so
+//  an edited row, or one added by hand, can be told from one an earlier
+//  load wrote.
+//
+package util
+
+import "fmt"
+import "sort"
+import "errors"
+import "database/sql"
+
+//
+//  Oid sources, for -oidsource
+//
+const (
+	Oidsourcefile  = "file"  // OID table file only; differing capolicies rows are replaced, with a warning
+	Oidsourcedb    = "db"    // capolicies only
+	Oidsourcemerge = "merge" // OID table file, with capolicies rows edited since loaded winning
+)
+
+//
+//  Oiddifference -- an OID which differs between the OID table and capolicies
+//
+type Oiddifference struct {
+	OID    string // the OID
+	Table  string // "CA level purpose" in OID table, "" if not there
+	DB     string // "CA level purpose" in capolicies, "" if not there
+	Edited bool   // capolicies row edited since loaded, so kept by a merge
+}
+
+//
+//  String -- for reports
+//
+func (d Oiddifference) String() string {
+	outcome := "OID table used, database row is from an earlier load"
+	if d.Edited {
+		outcome = "database kept, edited there"
+	}
+	switch {
+	case d.DB == "":
+		return fmt.Sprintf("OID %s only in OID table: %s", d.OID, d.Table)
+	case d.Table == "":
+		return fmt.Sprintf("OID %s only in database: %s (%s)", d.OID, d.DB, outcome)
+	}
+	return fmt.Sprintf("OID %s differs: OID table %s, database %s (%s)", d.OID, d.Table, d.DB, outcome)
+}
+
+//
+//  describepolicy -- CA, level, and purpose, for comparing
+//
+func describepolicy(caname string, level string, purpose string) string {
+	if purpose == "" {
+		purpose = PurposeTLS
+	}
+	if level == "" {
+		level = "-"
+	}
+	return fmt.Sprintf("'%s' %s %s", caname, level, purpose)
+}
+
+//
+//  Readoiddb -- read OID table entries from capolicies
+//
+//  Rows with no level which are for TLS were named from the enterprise
+//  number registry, not classified, and are skipped. Rows whose CA,
+//  level or purpose differ from Loaded_as are marked Edited.
+//
+func Readoiddb(dbcon *sql.DB) ([]Policyentry, error) {
+	rows, err := dbcon.Query("SELECT OID, Issuer_name, certlevel, CPS_url, Notes, Source, Purpose, Loaded_as FROM capolicies ORDER BY OID")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []Policyentry
+	for rows.Next() {
+		var e Policyentry
+		var caname, level, cps, notes, source, loadedas sql.NullString
+		err = rows.Scan(&e.OID, &caname, &level, &cps, &notes, &source, &e.Purpose, &loadedas)
+		if err != nil {
+			return nil, err
+		}
+		if !level.Valid && e.Purpose == PurposeTLS {
+			continue // named, not classified
+		}
+		e.CAname, e.Level, e.CPS, e.Notes, e.Source = caname.String, level.String, cps.String, notes.String, source.String
+		e.Edited = loadedas.String != describepolicy(e.CAname, e.Level, e.Purpose) // NULL if added by hand
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+//
+//  Loadoiddb -- load policy info from capolicies instead of an OID table file
+//
+//  entries are the capolicies rows, as read by Readoiddb. CA/B Forum
+//  reserved OIDs are added if capolicies does not have them.
+//
+func (c *CApolicyinfo) Loadoiddb(entries []Policyentry) error {
+	if len(entries) < 1 {
+		c.policyOID = nil
+		return errors.New("No CA policy OIDs found in capolicies table") // never loaded
+	}
+	c.policyOID = make(map[string]Policyinfo)
+	c.addcabforum() // capolicies rows replace these
+	c.addentries(entries)
+	return nil
+}
+
+//
+//  Mergeoiddb -- merge capolicies into policy info loaded from an OID table file
+//
+//  Rows analysts edited or added win, since that is where they fix
+//  classifications. Rows as an earlier load wrote them do not; they are
+//  only an older OID table. Returns the differences, in OID order.
+//
+func (c *CApolicyinfo) Mergeoiddb(entries []Policyentry) []Oiddifference {
+	if c.policyOID == nil {
+		panic("cainfo/mergeoiddb called without policies loaded.")
+	}
+	differences := mergepolicies(c.policyOID, entries)
+	var edited []Policyentry
+	for _, e := range entries {
+		if e.Edited {
+			edited = append(edited, e)
+		}
+	}
+	c.addentries(edited)
+	return differences
+}
+
+//
+//  Diffoiddb -- differences between policy info loaded from an OID table file and capolicies
+//
+//  Nothing is changed. Used to warn before capolicies rows are replaced.
+//
+func (c *CApolicyinfo) Diffoiddb(entries []Policyentry) []Oiddifference {
+	if c.policyOID == nil {
+		panic("cainfo/diffoiddb called without policies loaded.")
+	}
+	return mergepolicies(c.policyOID, entries)
+}
+
+//
+//  mergepolicies -- differences between the OID table and capolicies, in OID order
+//
+func mergepolicies(table map[string]Policyinfo, dbentries []Policyentry) []Oiddifference {
+	var differences []Oiddifference
+	indb := make(map[string]bool)
+	for _, e := range dbentries {
+		indb[e.OID] = true
+		dbdesc := describepolicy(e.CAname, e.Level, e.Purpose)
+		v, ok := table[e.OID]
+		if !ok {
+			differences = append(differences, Oiddifference{OID: e.OID, DB: dbdesc, Edited: e.Edited})
+			continue
+		}
+		if tabledesc := describepolicy(v.CAname, v.Policy, v.Purpose); tabledesc != dbdesc {
+			differences = append(differences, Oiddifference{OID: e.OID, Table: tabledesc, DB: dbdesc, Edited: e.Edited})
+		}
+	}
+	for oid, v := range table {
+		if !indb[oid] {
+			differences = append(differences, Oiddifference{OID: oid, Table: describepolicy(v.CAname, v.Policy, v.Purpose)})
+		}
+	}
+	sort.Slice(differences, func(i, j int) bool { return differences[i].OID < differences[j].OID })
+	return differences
+}
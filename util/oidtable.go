@@ -0,0 +1,267 @@
+//
+//  oidtable.go -- structured table of CA policy OIDs
+//
+//  The original table, catypetable.csv, is a spreadsheet export, with
+//  one row per CA, several OIDs to a cell, and cells such as "?",
+//  "None – not listed", and free text. The structured table is JSON,
+//  one entry per OID, keeping the CPS URL and notes. Readoidcsv
+//  converts the old format, and reports cells it could not interpret.
+//
+package util
+
+import "os"
+import "io"
+import "bufio"
+import "fmt"
+import "strings"
+import "unicode"
+import "path/filepath"
+import "encoding/csv"
+import "encoding/json"
+
+//
+//  Policyentry -- one policy OID of one CA
+//
+type Policyentry struct {
+	CAname  string `json:"ca_name"`           // name of CA
+	Level   string `json:"level"`             // "DV", "OV", "IV", or "EV"; "" if purpose has no validation
+	OID     string `json:"oid"`               // policy OID
+	Purpose string `json:"purpose,omitempty"` // "TLS" if empty; "CODESIGNING", "SMIME", etc.
+	CPS     string `json:"cps_url,omitempty"` // CPS document, if known
+	Notes   string `json:"notes,omitempty"`   // anything else
+	Source  string `json:"source,omitempty"`  // where this came from, "catypetable.csv line 7"
+	Edited  bool   `json:"-"`                 // read from capolicies, and changed there since loaded
+}
+
+//
+//  Oidtable -- the structured OID table file
+//
+type Oidtable struct {
+	Comment  string        `json:"comment,omitempty"`
+	Policies []Policyentry `json:"policies"`
+}
+
+//
+//  Oidcellproblem -- a cell of the CSV table which could not be fully interpreted
+//
+type Oidcellproblem struct {
+	Line   int    // line in CSV file
+	CAname string // CA of row
+	Column string // column heading
+	Value  string // cell contents
+	Reason string // what was wrong
+}
+
+//
+//  String -- for reports
+//
+func (p Oidcellproblem) String() string {
+	return fmt.Sprintf("Line %d, %s, %s: %s (%q)", p.Line, p.CAname, p.Column, p.Reason, p.Value)
+}
+
+//
+//  Columns of the CSV table. Levels are in this order. The OV column
+//  also has IV OIDs; the CSV table can't tell them apart.
+//
+var oidcsvlevels = []string{"DV", "OV", "EV"}
+
+const oidcsvcps = 4   // CPS URL column
+const oidcsvnotes = 5 // Notes column
+
+//
+//  Cells which mean no OIDs, and are not a problem.
+//
+var oidcellnone = map[string]bool{"": true, "none": true, "n/a": true, "none – not listed": true}
+
+//
+//  Validation levels, least to most.
+//
+var Policylevels = []string{"DV", "OV", "IV", "EV"}
+
+//
+//  Ispolicylevel -- true if a known certificate validation level
+//
+func Ispolicylevel(level string) bool {
+	for _, l := range Policylevels {
+		if level == l {
+			return true
+		}
+	}
+	return false
+}
+
+//
+//  isvalidoid -- OID syntax, and first arc 0, 1 or 2, as X.660 requires
+//
+//  Catches OIDs broken across lines in the spreadsheet, "...22234.2." "5.2.3.1".
+//
+func isvalidoid(s string) bool {
+	return IsOID(s) && s[0] >= '0' && s[0] <= '2' && s[1] == '.'
+}
+
+//
+//  parseoidcell -- OIDs in one cell, and why the rest of it was not understood, if it was not
+//
+func parseoidcell(cell string) ([]string, string) {
+	s := strings.TrimSpace(cell)
+	switch {
+	case oidcellnone[strings.ToLower(s)]:
+		return nil, ""
+	case s == "?":
+		return nil, "OIDs unknown"
+	case s == "ERROR":
+		return nil, "marked ERROR"
+	}
+	var oids, other []string
+	for _, tok := range strings.FieldsFunc(s, func(r rune) bool { return unicode.IsSpace(r) || r == ',' || r == ';' }) {
+		if isvalidoid(tok) {
+			oids = append(oids, tok)
+		} else {
+			other = append(other, tok)
+		}
+	}
+	if len(other) > 0 {
+		return oids, "text not understood: '" + strings.Join(other, " ") + "'"
+	}
+	return oids, ""
+}
+
+//
+//  Readoidcsv -- read CSV OID table into entries, with problems found
+//
+//  Format is: CA name, DV OIDs, OV OIDs, EV OIDs, CPS URL, Notes.
+//  Title and heading rows are skipped. OIDs are taken from cells even
+//  if some of the cell is not understood; the problem is reported.
+//
+func Readoidcsv(infilename string) ([]Policyentry, []Oidcellproblem, error) {
+	fi, err := os.Open(infilename) // open input file
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { // handle close
+		if err := fi.Close(); err != nil {
+			panic(err) // failed close is legit panic
+		}
+	}()
+	csvr := csv.NewReader(bufio.NewReader(fi)) // make a CSV reader
+	csvr.FieldsPerRecord = -1                  // rows vary
+	source := filepath.Base(infilename)
+	var entries []Policyentry
+	var problems []Oidcellproblem
+	for { // until EOF
+		fields, err := csvr.Read() // read one record
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := csvr.FieldPos(0)
+		caname := strings.TrimSpace(fields[0])
+		if caname == "" || len(fields) < 1+len(oidcsvlevels) || strings.HasPrefix(caname, "Certificate authority") {
+			continue // blank, title, or heading row
+		}
+		var cps, notes string
+		if len(fields) > oidcsvcps {
+			cps = strings.TrimSpace(fields[oidcsvcps])
+		}
+		if len(fields) > oidcsvnotes {
+			notes = strings.TrimSpace(fields[oidcsvnotes])
+		}
+		for i, level := range oidcsvlevels {
+			oids, reason := parseoidcell(fields[1+i])
+			if reason != "" {
+				problems = append(problems, Oidcellproblem{Line: line, CAname: caname, Column: level + " OID", Value: fields[1+i], Reason: reason})
+			}
+			for _, oid := range oids {
+				entries = append(entries, Policyentry{CAname: caname, Level: level, OID: oid, CPS: cps, Notes: notes,
+					Source: fmt.Sprintf("%s line %d", source, line)})
+			}
+		}
+	}
+	return entries, problems, nil
+}
+
+//
+//  Readoidtable -- read structured (JSON) OID table
+//
+//  Unlike the CSV table, anything wrong is an error.
+//
+func Readoidtable(infilename string) ([]Policyentry, error) {
+	data, err := os.ReadFile(infilename)
+	if err != nil {
+		return nil, err
+	}
+	var table Oidtable
+	err = json.Unmarshal(data, &table)
+	if err != nil {
+		return nil, fmt.Errorf("OID table %s: %s", infilename, err)
+	}
+	for i, e := range table.Policies {
+		levelok := Ispolicylevel(e.Level) || (e.Level == "" && e.Purpose != "" && e.Purpose != PurposeTLS)
+		if !isvalidoid(e.OID) || !levelok || e.CAname == "" {
+			return nil, fmt.Errorf("OID table %s: bad entry %d: %+v", infilename, i+1, e)
+		}
+	}
+	return table.Policies, nil
+}
+
+//
+//  Writeoidtable -- write structured (JSON) OID table
+//
+func Writeoidtable(outfilename string, comment string, entries []Policyentry) error {
+	data, err := json.MarshalIndent(Oidtable{Comment: comment, Policies: entries}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outfilename, append(data, '\n'), 0644)
+}
+
+//
+//  Writeoidcsv -- write OID table entries in the CSV format, one row per entry
+//
+//  IV OIDs go in the OV column, as in the original table. Entries with
+//  no level can't be written in this format.
+//
+func Writeoidcsv(outfilename string, title string, entries []Policyentry) error {
+	fo, err := os.Create(outfilename)
+	if err != nil {
+		return err
+	}
+	csvw := csv.NewWriter(fo)
+	csvw.Write([]string{title, "", "", "", "", ""})
+	csvw.Write([]string{"Certificate authority", "DV OID", "OV (or IV) OID", "EV OID", "CPS URL", "Notes"})
+	for _, e := range entries {
+		level := e.Level
+		if level == "IV" {
+			level = "OV"
+		}
+		row := make([]string, oidcsvnotes+1)
+		row[0] = e.CAname
+		for i, l := range oidcsvlevels {
+			if l == level {
+				row[1+i] = e.OID
+			}
+		}
+		if row[1] == "" && row[2] == "" && row[3] == "" {
+			fo.Close()
+			return fmt.Errorf("OID %s: level '%s' can't be written to CSV OID table", e.OID, e.Level)
+		}
+		row[oidcsvcps] = e.CPS
+		row[oidcsvnotes] = e.Notes
+		csvw.Write(row)
+	}
+	csvw.Flush()
+	if err := csvw.Error(); err != nil {
+		fo.Close()
+		return err
+	}
+	return fo.Close()
+}
+
+//
+//  Isoidtable -- true if file name is of a structured OID table
+//
+func Isoidtable(filename string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".json")
+}
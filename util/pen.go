@@ -0,0 +1,104 @@
+//
+//  pen.go -- IANA Private Enterprise Number registry
+//
+//  Policy OIDs under 1.3.6.1.4.1.N belong to whoever registered
+//  enterprise number N. That identifies the CA of many OIDs which
+//  are not in the OID table. The registry is a local copy of
+//  "https://www.iana.org/assignments/enterprise-numbers.txt", which
+//  has one entry per number:
+//
+//    6449
+//      Sectigo Limited
+//        Contact Name
+//          contact&example.com
+//
+package util
+
+import "os"
+import "bufio"
+import "strings"
+import "errors"
+
+//
+//  Enterprisenumbers -- registered organization of each enterprise number
+//
+type Enterprisenumbers struct {
+	orgs map[string]string // organization, by enterprise number
+}
+
+//
+//  isdecimal -- true if all decimal digits
+//
+func isdecimal(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, ch := range s {
+		if ch < '0' || ch > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+//
+//  Loadenterprisenumbers -- load IANA enterprise numbers file
+//
+func (p *Enterprisenumbers) Loadenterprisenumbers(infilename string) error {
+	p.orgs = make(map[string]string)
+	fi, err := os.Open(infilename) // open input file
+	if err != nil {
+		return err
+	}
+	defer func() { // handle close
+		if err := fi.Close(); err != nil {
+			panic(err) // failed close is legit panic
+		}
+	}()
+	scanner := bufio.NewScanner(fi)
+	number := "" // number of entry being read, if expecting its organization
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		switch {
+		case isdecimal(line): // number, at left margin
+			number = line
+		case number != "" && strings.HasPrefix(line, "  ") && !strings.HasPrefix(line, "   "):
+			org := strings.TrimSpace(line) // organization, indented by 2
+			if org != "" && org != "---none---" {
+				p.orgs[number] = org
+			}
+			number = ""
+		case line != "":
+			number = "" // not an entry
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(p.orgs) < 1 {
+		return errors.New("No enterprise numbers found in file: " + infilename) // must be bogus file
+	}
+	return nil
+}
+
+//
+//  Organization -- registered organization of the enterprise arc of an OID
+//
+func (p *Enterprisenumbers) Organization(oid string) (string, bool) {
+	arc := Enterprisearc(oid)
+	if p == nil || arc == "" {
+		return "", false
+	}
+	org, ok := p.orgs[arc[len(enterprisearcprefix):]]
+	return org, ok
+}
+
+//
+//  Count -- number of enterprise numbers loaded
+//
+func (p *Enterprisenumbers) Count() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.orgs)
+}
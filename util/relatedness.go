@@ -0,0 +1,238 @@
+//
+//  relatedness.go -- do the 2LDs of a certificate belong to one owner?
+//
+//  "example.com", "example.co.uk" and "example.de" on one cert are one
+//  owner. A CDN cert with 50 unrelated customer domains is not. This
+//  scores a cert's 2LDs from 0 (unrelated) to 1 (all related).
+//
+package util
+
+import "os"
+import "io"
+import "bufio"
+import "strings"
+import "errors"
+import "unicode"
+import "encoding/csv"
+
+//
+//  Intermediaries -- known network intermediaries, such as CDNs
+//
+//  Certs of these carry many unrelated customer domains.
+//
+type Intermediaries struct {
+	domains map[string]string // 2LD -> note
+}
+
+//
+//  Loadintermediaries -- load intermediaries from OV blacklist file
+//
+//  Format is: domain, organization, issuer, level, count, T/F, note.
+//  Only "T" rows (network intermediaries) are used.
+//
+func (m *Intermediaries) Loadintermediaries(infilename string) error {
+	fi, err := os.Open(infilename) // open input file
+	if err != nil {
+		return err
+	}
+	defer func() { // handle close
+		if err := fi.Close(); err != nil {
+			panic(err) // failed close is legit panic
+		}
+	}()
+	csvr := csv.NewReader(bufio.NewReader(fi)) // make a CSV reader
+	csvr.FieldsPerRecord = -1                  // trailing comma on some lines
+	m.domains = make(map[string]string)
+	for { // until EOF
+		fields, err := csvr.Read() // read one record
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			m.domains = nil
+			return err
+		}
+		if len(fields) < 7 || strings.TrimSpace(fields[5]) != "T" { // not an intermediary
+			continue
+		}
+		m.domains[strings.ToLower(strings.TrimSpace(fields[0]))] = strings.TrimSpace(fields[6])
+	}
+	if len(m.domains) < 1 {
+		m.domains = nil
+		return errors.New("No intermediaries found in file: " + infilename) // must be bogus file
+	}
+	return nil
+}
+
+//
+//  Isintermediary -- true, with note, if 2LD is a known intermediary
+//
+func (m *Intermediaries) Isintermediary(domain string) (string, bool) {
+	note, ok := m.domains[domain]
+	return note, ok
+}
+
+//
+//  Relatedness -- how related a cert's 2LDs are, and why
+//
+type Relatedness struct {
+	Score        float64 // fraction of 2LDs related to another 2LD or the organization, 0 to 1
+	Sharedlabel  int     // 2LDs with the same label as another, "example.com", "example.de"
+	Orgtoken     int     // 2LDs with a word of the Subject organization
+	Sharedtoken  int     // 2LDs sharing a word or brand with another, "paypal.com", "paypal-community.com"
+	Intermediary string  // 2LD of known intermediary on a cert of several owners, if any; score is then 0
+}
+
+//
+//  Relatednessscorer -- scores certs, with brands and intermediaries if any
+//
+type Relatednessscorer struct {
+	brands         []Brand
+	intermediaries *Intermediaries
+}
+
+//
+//  Shortest word which counts as shared. "web", "net", etc. are everywhere.
+//
+const minsharedtoken = 4
+
+//
+//  Words in organization names which say nothing about who it is.
+//
+var orgstopwords = map[string]bool{
+	"inc": true, "incorporated": true, "llc": true, "ltd": true, "limited": true, "corp": true,
+	"corporation": true, "company": true, "gmbh": true, "ag": true, "sa": true, "plc": true,
+	"the": true, "and": true, "of": true, "group": true, "holdings": true, "co": true,
+}
+
+//
+//  Init -- set up with brand list and intermediaries, either of which may be nil
+//
+func (r *Relatednessscorer) Init(brands *Brandlist, intermediaries *Intermediaries) {
+	r.brands = nil
+	if brands != nil {
+		r.brands = brands.Brands
+	}
+	r.intermediaries = intermediaries
+}
+
+//
+//  labeltokens -- words of a second level label, plus any brand names in it
+//
+func (r *Relatednessscorer) labeltokens(label string) []string {
+	tokens := strings.FieldsFunc(label, func(c rune) bool { return !unicode.IsLetter(c) })
+	for i := range r.brands {
+		if name := r.brands[i].Name; len(name) >= minsharedtoken && strings.Contains(label, name) {
+			tokens = append(tokens, name) // "paypalobjects" has "paypal"
+		}
+	}
+	return tokens
+}
+
+//
+//  orgtokens -- significant words of an organization name, lower case
+//
+func orgtokens(org string) []string {
+	words := strings.FieldsFunc(strings.ToLower(org), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+	tokens := make([]string, 0, len(words))
+	for _, w := range words {
+		if len(w) >= 3 && !orgstopwords[w] {
+			tokens = append(tokens, w)
+		}
+	}
+	return tokens
+}
+
+//
+//  matchesorg -- true if a second level label goes with the organization
+//
+//  "sonymusic" goes with "Sony Music Entertainment", as does "sony".
+//
+func matchesorg(label string, tokens []string) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	for _, t := range tokens {
+		if len(t) >= minsharedtoken && strings.Contains(label, t) {
+			return true
+		}
+	}
+	return len(label) >= minsharedtoken && strings.Contains(strings.Join(tokens, ""), label)
+}
+
+//
+//  Score -- relatedness of a cert's unique 2LDs, given its Subject organization
+//
+//  A 2LD is related if it shares its label with another 2LD, goes with
+//  the organization, or shares a word or brand with another 2LD. The
+//  score is the fraction of 2LDs which are related. One 2LD scores 1.
+//  A cert of several owners with a known intermediary's 2LD on it is
+//  a CDN's customer cert, and scores 0; the intermediary's own certs,
+//  "cloudflare.com" and "cloudflare.net", score as usual.
+//
+func (r *Relatednessscorer) Score(domains2ld []string, organization string) Relatedness {
+	var rel Relatedness
+	if len(domains2ld) < 2 {
+		rel.Score = 1
+		return rel
+	}
+	labels := make([]string, len(domains2ld))
+	labelcount := make(map[string]int)
+	tokencount := make(map[string]int) // number of 2LDs with each token
+	tokens := make([][]string, len(domains2ld))
+	for i, d := range domains2ld {
+		labels[i] = d
+		if dot := strings.IndexByte(d, '.'); dot >= 0 {
+			labels[i] = d[:dot]
+		}
+		labelcount[labels[i]]++
+		seen := make(map[string]bool)
+		for _, t := range r.labeltokens(labels[i]) {
+			if len(t) >= minsharedtoken && !seen[t] {
+				seen[t] = true
+				tokens[i] = append(tokens[i], t)
+				tokencount[t]++
+			}
+		}
+	}
+	org := orgtokens(organization)
+	related := 0
+	for i := range domains2ld {
+		switch {
+		case labelcount[labels[i]] > 1:
+			rel.Sharedlabel++
+		case matchesorg(labels[i], org):
+			rel.Orgtoken++
+		case sharestoken(tokens[i], tokencount):
+			rel.Sharedtoken++
+		default:
+			continue
+		}
+		related++
+	}
+	rel.Score = float64(related) / float64(len(domains2ld))
+	if rel.Score < 1 && r.intermediaries != nil { // several owners
+		for _, d := range domains2ld {
+			if _, ok := r.intermediaries.Isintermediary(d); ok {
+				rel.Intermediary = d
+				rel.Score = 0
+				break
+			}
+		}
+	}
+	return rel
+}
+
+//
+//  sharestoken -- true if any of these tokens is also in another 2LD
+//
+func sharestoken(tokens []string, tokencount map[string]int) bool {
+	for _, t := range tokens {
+		if tokencount[t] > 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+//
+//  sqliteloader.go -- loading tables into SQLite, which has no LOAD DATA INFILE
+//
+//  Takes the same lines as SQLdataloader, made by ToSQLline, and the
+//  same load parameters, and turns them into INSERT statements, a
+//  transaction of them at a time.
+//
+package util
+
+import "fmt"
+import "strings"
+import "database/sql"
+
+//
+//  Tableloader -- bulk loader of ToSQLline lines into one table
+//
+//  Open takes LOAD DATA parameters, "INTO TABLE certs" or
+//  "REPLACE INTO TABLE capolicies".
+//
+type Tableloader interface {
+	Open(loadparams string, db *sql.DB, recmax int32, verbose bool)
+	Write(s string) error
+	Close() error
+}
+
+//
+//  SQLiteloader -- loads ToSQLline lines into SQLite with INSERT
+//
+//  As with LOAD DATA LOCAL, rows with a key already in the table are
+//  skipped, or replaced if the parameters say REPLACE.
+//
+type SQLiteloader struct {
+	db         *sql.DB         // database handle
+	insert     string          // "INSERT OR IGNORE INTO table"
+	rows       [][]interface{} // rows not yet inserted
+	recmax     int32           // max rows per transaction
+	totalcount int64           // total rows loaded
+	verbose    bool            // true if verbose mode
+}
+
+//
+//  Open -- begin a loading operation
+//
+//  Write does all the work.  This just saves params; bad params are a program bug.
+//
+func (d *SQLiteloader) Open(loadparams string, db *sql.DB, recmax int32, verbose bool) {
+	words := strings.Fields(loadparams)
+	conflict := "IGNORE"
+	if len(words) > 0 && strings.ToUpper(words[0]) == "REPLACE" {
+		conflict = "REPLACE"
+		words = words[1:]
+	}
+	if len(words) != 3 || strings.ToUpper(words[0]) != "INTO" || strings.ToUpper(words[1]) != "TABLE" {
+		panic("SQLite loader Open called with load parameters '" + loadparams + "'") // program bug
+	}
+	d.db = db
+	d.insert = "INSERT OR " + conflict + " INTO " + words[2]
+	d.rows = nil
+	d.recmax = recmax
+	d.totalcount = 0
+	d.verbose = verbose
+}
+
+//
+//  Write -- write one or more lines
+//
+func (d *SQLiteloader) Write(s string) error {
+	if len(s) == 0 { // nothing to write
+		return nil
+	}
+	rows, err := Unpacksqllines(s)
+	if err != nil {
+		return err
+	}
+	d.rows = append(d.rows, rows...)
+	if int32(len(d.rows)) >= d.recmax { // enough rows for a transaction
+		return d.doload()
+	}
+	return nil
+}
+
+//
+//  Close -- end a loading operation
+//
+func (d *SQLiteloader) Close() error {
+	err := d.doload() // final rows, if any
+	if err != nil {
+		return err
+	}
+	if d.verbose && d.totalcount > 0 {
+		fmt.Printf("Successfully loaded %d records into database.\n", d.totalcount)
+	}
+	return nil
+}
+
+//
+//  doload -- insert the rows written so far, in one transaction
+//
+func (d *SQLiteloader) doload() error {
+	if len(d.rows) == 0 {
+		return nil
+	}
+	rows := d.rows
+	d.rows = nil
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmts := make(map[int]*sql.Stmt) // by field count
+	for _, row := range rows {
+		stmt := stmts[len(row)]
+		if stmt == nil {
+			stmt, err = tx.Prepare(d.insert + " VALUES (" + strings.TrimSuffix(strings.Repeat("?,", len(row)), ",") + ")")
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			stmts[len(row)] = stmt
+		}
+		_, err = stmt.Exec(row...)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %s", d.insert, err)
+		}
+	}
+	err = tx.Commit() // also closes the statements
+	if err != nil {
+		return err
+	}
+	d.totalcount += int64(len(rows))
+	if d.verbose {
+		fmt.Printf("Loaded %d records: %s\n", len(rows), d.insert)
+	}
+	return nil
+}
+
+//
+//  Unpacksqllines -- fields of lines made by ToSQLline
+//
+//  Enclosed fields become strings, without their escapes. NONE, which
+//  ToSQLstring and ToSQLint write for no value, becomes nil, for NULL.
+//
+func Unpacksqllines(s string) ([][]interface{}, error) {
+	var rows [][]interface{}
+	var fields []interface{} // of current line
+	var field strings.Builder
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == SQLQUOTE[0]: // enclosed field
+			field.Reset()
+			for i++; i < len(s) && s[i] != SQLQUOTE[0]; i++ {
+				if s[i] == '\\' && i+1 < len(s) { // escaped character, taken as is
+					i++
+				}
+				field.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("SQL line, field not terminated: %q", s)
+			}
+			i++ // past closing quote
+			fields = append(fields, field.String())
+		case strings.HasPrefix(s[i:], "NONE"):
+			fields = append(fields, nil)
+			i += len("NONE")
+		default:
+			return nil, fmt.Errorf("SQL line, field %d not understood: %q", len(fields)+1, s)
+		}
+		if i >= len(s) {
+			break // line not terminated, caught below
+		}
+		switch s[i] {
+		case ',': // next field
+			i++
+		case '\n': // next line
+			rows = append(rows, fields)
+			fields = nil
+			i++
+		default:
+			return nil, fmt.Errorf("SQL line, no separator after field %d: %q", len(fields), s)
+		}
+	}
+	if fields != nil {
+		return nil, fmt.Errorf("SQL line not terminated: %q", s)
+	}
+	return rows, nil
+}
@@ -0,0 +1,290 @@
+//
+//  typosquat.go -- find domains which are near misses of brand domains
+//
+//  "paypa1.com", "paypak.com" and "amazon-secure-login.net" are all
+//  registered to catch users of the real thing.
+//
+package util
+
+import "strings"
+
+//
+//  Typosquatrule -- which rule found a near miss
+//
+type Typosquatrule int
+
+const (
+	Typohomoglyph    Typosquatrule = iota // looks the same, "paypa1"
+	Typobitsquat                          // one bit flipped in one character, "paypcl"
+	Typokeyboard                          // adjacent key hit instead of or as well as the right one, "paypak"
+	Typoeditdistance                      // a letter or two added, dropped, changed or swapped, "papyal"
+	Typoembedded                          // brand name inside a longer name, "paypal-login"
+)
+
+//
+//  String -- rule name, for reports
+//
+func (r Typosquatrule) String() string {
+	switch r {
+	case Typohomoglyph:
+		return "homoglyph"
+	case Typobitsquat:
+		return "bitsquat"
+	case Typokeyboard:
+		return "keyboard"
+	case Typoeditdistance:
+		return "editdistance"
+	case Typoembedded:
+		return "embedded"
+	}
+	return "unknown"
+}
+
+//
+//  Typosquatmatch -- a 2LD which is a near miss of a brand
+//
+type Typosquatmatch struct {
+	Domain string        // the 2LD, "paypa1.com"
+	Brand  string        // the brand domain, "paypal.com"
+	Rule   Typosquatrule // first rule which fired
+}
+
+//
+//  Typosquats -- typosquat detector
+//
+type Typosquats struct {
+	brands []Brand         // brands to compare against
+	conf   *Confusables    // for homoglyphs, if any
+	owned  map[string]bool // brand domains and their owners' other domains. Set.
+	rows   []int           // work space for edit distance
+}
+
+//
+//  Shortest brand names for the looser rules. Shorter names match too much.
+//
+const typominedit = 5     // edit distance
+const typominembedded = 4 // embedded in a longer name
+
+//
+//  Init -- set up detector with brand list and, optionally, confusables table
+//
+//  brands may be nil, in which case nothing is a typosquat.
+//
+func (t *Typosquats) Init(brands *Brandlist, conf *Confusables) {
+	t.brands = nil
+	if brands != nil {
+		t.brands = brands.Brands
+	}
+	t.conf = conf
+	t.owned = make(map[string]bool)
+	for i := range t.brands {
+		t.owned[t.brands[i].Domain] = true
+		for _, domain := range t.brands[i].Owned {
+			t.owned[domain] = true
+		}
+	}
+}
+
+//
+//  Check -- check a 2LD ("example.com") against the brand list
+//
+//  The brand domains themselves, other domains of their owners listed
+//  in the brand file ("amazonaws.com" for "amazon.com"), and other TLDs
+//  of the brand name, are not flagged. Rules are tried from most to
+//  least specific.
+//
+func (t *Typosquats) Check(domain string) (Typosquatmatch, bool) {
+	if t.owned[domain] { // a brand's own domain
+		return Typosquatmatch{Domain: domain}, false
+	}
+	label := domain // second level label
+	if i := strings.IndexByte(domain, '.'); i >= 0 {
+		label = domain[:i]
+	}
+	for i := range t.brands {
+		brand := &t.brands[i]
+		if label == brand.Name { // the brand itself, in some TLD
+			continue
+		}
+		rule, ok := t.checklabel(label, brand.Name)
+		if ok {
+			return Typosquatmatch{Domain: domain, Brand: brand.Domain, Rule: rule}, true
+		}
+	}
+	return Typosquatmatch{Domain: domain}, false
+}
+
+//
+//  checklabel -- compare one label with one brand name, which differ
+//
+func (t *Typosquats) checklabel(label string, name string) (Typosquatrule, bool) {
+	if t.conf != nil && t.conf.Skeleton(label) == t.conf.Skeleton(name) {
+		return Typohomoglyph, true
+	}
+	if Isbitsquat(label, name) {
+		return Typobitsquat, true
+	}
+	if Iskeyboardtypo(label, name) {
+		return Typokeyboard, true
+	}
+	if len(name) >= typominedit {
+		maxdist := 1
+		if len(name) > 8 {
+			maxdist = 2
+		}
+		if editdistance(label, name, &t.rows) <= maxdist {
+			return Typoeditdistance, true
+		}
+	}
+	if len(name) >= typominembedded && strings.Contains(label, name) {
+		return Typoembedded, true
+	}
+	return 0, false
+}
+
+//
+//  onediff -- index of the only differing byte of two same-length strings, or -1
+//
+func onediff(a string, b string) int {
+	if len(a) != len(b) {
+		return -1
+	}
+	diff := -1
+	for i := 0; i < len(a); i++ {
+		if a[i] != b[i] {
+			if diff >= 0 {
+				return -1 // more than one
+			}
+			diff = i
+		}
+	}
+	return diff
+}
+
+//
+//  Isbitsquat -- true if a is b with one bit flipped in one character
+//
+//  Memory errors do this. Only flips giving valid host name characters count.
+//
+func Isbitsquat(a string, b string) bool {
+	i := onediff(a, b)
+	if i < 0 {
+		return false
+	}
+	x := a[i] ^ b[i]
+	return x&(x-1) == 0 && ishostbyte(a[i]) // exactly one bit
+}
+
+//
+//  ishostbyte -- true for characters allowed in a host name label
+//
+func ishostbyte(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-'
+}
+
+//
+//  QWERTY keyboard rows, for adjacent keys.
+//
+var keyboardrows = []string{"1234567890-", "qwertyuiop", "asdfghjkl", "zxcvbnm"}
+
+//
+//  keyadjacent -- true if keys for a and b are next to each other on a QWERTY keyboard
+//
+//  Counts left, right, and the keys above and below which overlap.
+//
+func keyadjacent(a byte, b byte) bool {
+	for r := range keyboardrows {
+		i := strings.IndexByte(keyboardrows[r], a)
+		if i < 0 {
+			continue
+		}
+		row := keyboardrows[r]
+		if (i > 0 && row[i-1] == b) || (i+1 < len(row) && row[i+1] == b) {
+			return true
+		}
+		for _, other := range []int{r - 1, r + 1} { // rows above and below are offset half a key
+			if other < 0 || other >= len(keyboardrows) {
+				continue
+			}
+			j := strings.IndexByte(keyboardrows[other], b)
+			if j >= 0 && (j == i || (other < r && j == i+1) || (other > r && j == i-1)) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+//
+//  Iskeyboardtypo -- true if a is b with one key replaced by, or doubled with, an adjacent one
+//
+func Iskeyboardtypo(a string, b string) bool {
+	if i := onediff(a, b); i >= 0 { // substitution
+		return keyadjacent(b[i], a[i])
+	}
+	if len(a) != len(b)+1 { // insertion
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if a[:i]+a[i+1:] != b {
+			continue
+		}
+		if (i > 0 && keyadjacent(a[i-1], a[i])) || (i+1 < len(a) && keyadjacent(a[i+1], a[i])) {
+			return true // extra key next to a neighbor
+		}
+	}
+	return false
+}
+
+//
+//  Editdistance -- Damerau-Levenshtein distance, optimal string alignment version
+//
+//  Insertions, deletions, substitutions and transpositions of adjacent
+//  characters each count one. Works on bytes; names are compared as stored.
+//
+func Editdistance(a string, b string) int {
+	var rows []int
+	return editdistance(a, b, &rows)
+}
+
+//
+//  editdistance -- Editdistance, with work space kept in rows between calls
+//
+//  Only the last three rows of the table are needed: the one being
+//  filled, the one above for the usual edits, and the one above that
+//  for transpositions.
+//
+func editdistance(a string, b string, rows *[]int) int {
+	n := len(b) + 1
+	if cap(*rows) < 3*n {
+		*rows = make([]int, 3*n)
+	}
+	buf := (*rows)[:3*n]
+	prev2, prev, cur := buf[:n], buf[n:2*n], buf[2*n:]
+	for j := 0; j < n; j++ {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			best := prev[j-1] + cost // substitution
+			if prev[j]+1 < best {
+				best = prev[j] + 1 // deletion
+			}
+			if cur[j-1]+1 < best {
+				best = cur[j-1] + 1 // insertion
+			}
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && prev2[j-2]+1 < best {
+				best = prev2[j-2] + 1 // transposition
+			}
+			cur[j] = best
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
@@ -9,7 +9,13 @@
 //
 package util
 
+import "fmt"
 import "time"
+import "strings"
+import "os"
+import "path/filepath"
+import "crypto/sha256"
+import "encoding/hex"
 import "testing"
 
 //
@@ -34,3 +40,1028 @@ func TestTLDinfo(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+//
+//  TestUnpacksqllines -- ToSQLline lines back to fields, for SQLiteloader
+//
+func TestUnpacksqllines(t *testing.T) {
+	line1 := ToSQLline([]string{ToSQLint("123"), ToSQLstring(" Now, \"think\"\nLine 2. \\"), ToSQLstring(""), ToSQLbool("TRUE")})
+	line2 := ToSQLline([]string{ToSQLstring("NONE"), ToSQLint("x")})
+	rows, err := Unpacksqllines(line1 + line2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]interface{}{{"123", " Now, \"think\"\nLine 2. \\", nil, "1"}, {"NONE", nil}}
+	if fmt.Sprintf("%q", rows) != fmt.Sprintf("%q", expected) {
+		t.Errorf("Unpacksqllines: got %q, expected %q", rows, expected)
+	}
+	for _, bad := range []string{"\"123\"", "\"12,\n", "123\n", "\"1\"\"2\"\n", "\"1\","} {
+		if rows, err := Unpacksqllines(bad); err == nil {
+			t.Errorf("Unpacksqllines(%q) = %q, expected error", bad, rows)
+		}
+	}
+}
+
+//
+//  Public Suffix List test vectors, from
+//  "https://raw.githubusercontent.com/publicsuffix/list/master/tests/test_psl.txt".
+//  "" means null. Vectors which depend on the PRIVATE section are omitted.
+//
+var psltests = [][2]string{
+	// Mixed case.
+	{"COM", ""},
+	{"example.COM", "example.com"},
+	{"WwW.example.COM", "example.com"},
+	// Leading dot.
+	{".com", ""},
+	{".example", ""},
+	{".example.com", ""},
+	{".example.example", ""},
+	// Unlisted TLD.
+	{"example", ""},
+	{"example.example", "example.example"},
+	{"b.example.example", "example.example"},
+	{"a.b.example.example", "example.example"},
+	// TLD with only 1 rule.
+	{"biz", ""},
+	{"domain.biz", "domain.biz"},
+	{"b.domain.biz", "domain.biz"},
+	{"a.b.domain.biz", "domain.biz"},
+	// TLD with some 2-level rules.
+	{"com", ""},
+	{"example.com", "example.com"},
+	{"b.example.com", "example.com"},
+	{"a.b.example.com", "example.com"},
+	{"test.ac", "test.ac"},
+	// TLD with only 1 (wildcard) rule.
+	{"mm", ""},
+	{"c.mm", ""},
+	{"b.c.mm", "b.c.mm"},
+	{"a.b.c.mm", "b.c.mm"},
+	// More complex TLD.
+	{"jp", ""},
+	{"test.jp", "test.jp"},
+	{"www.test.jp", "test.jp"},
+	{"ac.jp", ""},
+	{"test.ac.jp", "test.ac.jp"},
+	{"www.test.ac.jp", "test.ac.jp"},
+	{"kyoto.jp", ""},
+	{"test.kyoto.jp", "test.kyoto.jp"},
+	{"ide.kyoto.jp", ""},
+	{"b.ide.kyoto.jp", "b.ide.kyoto.jp"},
+	{"a.b.ide.kyoto.jp", "b.ide.kyoto.jp"},
+	{"c.kobe.jp", ""},
+	{"b.c.kobe.jp", "b.c.kobe.jp"},
+	{"a.b.c.kobe.jp", "b.c.kobe.jp"},
+	{"city.kobe.jp", "city.kobe.jp"},
+	{"www.city.kobe.jp", "city.kobe.jp"},
+	// TLD with a wildcard rule and exceptions.
+	{"ck", ""},
+	{"test.ck", ""},
+	{"b.test.ck", "b.test.ck"},
+	{"a.b.test.ck", "b.test.ck"},
+	{"www.ck", "www.ck"},
+	{"www.www.ck", "www.ck"},
+	// US K12.
+	{"us", ""},
+	{"test.us", "test.us"},
+	{"www.test.us", "test.us"},
+	{"ak.us", ""},
+	{"test.ak.us", "test.ak.us"},
+	{"www.test.ak.us", "test.ak.us"},
+	{"k12.ak.us", ""},
+	{"test.k12.ak.us", "test.k12.ak.us"},
+	{"www.test.k12.ak.us", "test.k12.ak.us"},
+	// IDN labels.
+	{"食狮.com.cn", "食狮.com.cn"},
+	{"食狮.公司.cn", "食狮.公司.cn"},
+	{"www.食狮.公司.cn", "食狮.公司.cn"},
+	{"shishi.公司.cn", "shishi.公司.cn"},
+	{"公司.cn", ""},
+	{"食狮.中国", "食狮.中国"},
+	{"www.食狮.中国", "食狮.中国"},
+	{"shishi.中国", "shishi.中国"},
+	{"中国", ""},
+	// Same as above, but punycoded.
+	{"xn--85x722f.com.cn", "xn--85x722f.com.cn"},
+	{"xn--85x722f.xn--55qx5d.cn", "xn--85x722f.xn--55qx5d.cn"},
+	{"www.xn--85x722f.xn--55qx5d.cn", "xn--85x722f.xn--55qx5d.cn"},
+	{"shishi.xn--55qx5d.cn", "shishi.xn--55qx5d.cn"},
+	{"xn--55qx5d.cn", ""},
+	{"xn--85x722f.xn--fiqs8s", "xn--85x722f.xn--fiqs8s"},
+	{"www.xn--85x722f.xn--fiqs8s", "xn--85x722f.xn--fiqs8s"},
+	{"shishi.xn--fiqs8s", "shishi.xn--fiqs8s"},
+	{"xn--fiqs8s", ""},
+}
+
+const testsuffixfile = "../data/effective_tld_names.dat"
+
+//
+//  TestPublicsuffix -- check against official Public Suffix List test vectors
+//
+func TestPublicsuffix(t *testing.T) {
+	var d DomainSuffixes
+	err := d.Loadpublicsuffixlist(testsuffixfile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range psltests {
+		got, _ := d.Registrabledomain(test[0])
+		if got != test[1] {
+			t.Errorf("Registrabledomain(%q) = %q, expected %q", test[0], got, test[1])
+		}
+	}
+	//  Domainparts is unchanged for normal names, and still rejects unlisted TLDs.
+	sub, second, tld, ok := d.Domainparts("www.sub.example.co.uk")
+	if !ok || sub != "www.sub" || second != "example" || tld != "co.uk" {
+		t.Errorf("Domainparts: got %q %q %q %t", sub, second, tld, ok)
+	}
+	_, second, tld, ok = d.Domainparts("a.b.test.ck")
+	if !ok || second != "b" || tld != "test.ck" {
+		t.Errorf("Domainparts wildcard: got %q %q %t", second, tld, ok)
+	}
+	if _, _, _, ok = d.Domainparts("host.example"); ok {
+		t.Errorf("Domainparts accepted unlisted TLD")
+	}
+}
+
+//
+//  TestSuffixlistinfo -- version, commit and hash of list loaded
+//
+func TestSuffixlistinfo(t *testing.T) {
+	const list = "// ===BEGIN ICANN DOMAINS===\n// VERSION: 2024-05-31_09-26-35_UTC\n// COMMIT: 8ef6b8c\ncom\n// ===END ICANN DOMAINS===\n"
+	infile := filepath.Join(t.TempDir(), "psl.dat")
+	err := os.WriteFile(infile, []byte(list), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d DomainSuffixes
+	err = d.Loadpublicsuffixlist(infile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256([]byte(list))
+	if d.Info.Version != "2024-05-31_09-26-35_UTC" || d.Info.Commit != "8ef6b8c" || d.Info.Hash != hex.EncodeToString(hash[:]) {
+		t.Errorf("Suffix list info wrong: %+v", d.Info)
+	}
+	err = d.Loadpublicsuffixlist(testsuffixfile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Info.Version != "" || len(d.Info.Hash) != 64 || !d.Info.Private {
+		t.Errorf("Unversioned suffix list info wrong: %+v", d.Info)
+	}
+}
+
+//
+//  TestPublicsuffixprivate -- PRIVATE section, loaded on request
+//
+func TestPublicsuffixprivate(t *testing.T) {
+	var d DomainSuffixes
+	err := d.Loadpublicsuffixlist(testsuffixfile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, tld, section, ok := d.Domaininfo("test.blogspot.com")
+	if !ok || second != "blogspot" || tld != "com" || section != Sectionicann {
+		t.Errorf("ICANN only: got %q %q %s %t", second, tld, section, ok)
+	}
+	err = d.Loadpublicsuffixlist(testsuffixfile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, tld, section, ok = d.Domaininfo("test.blogspot.com")
+	if !ok || second != "test" || tld != "blogspot.com" || section != Sectionprivate {
+		t.Errorf("With PRIVATE: got %q %q %s %t", second, tld, section, ok)
+	}
+	for _, test := range [][2]string{ // vectors for PRIVATE section
+		{"uk.com", ""},
+		{"example.uk.com", "example.uk.com"},
+		{"b.example.uk.com", "example.uk.com"},
+		{"a.b.example.uk.com", "example.uk.com"},
+	} {
+		got, _ := d.Registrabledomain(test[0])
+		if got != test[1] {
+			t.Errorf("Registrabledomain(%q) = %q, expected %q", test[0], got, test[1])
+		}
+	}
+}
+
+//
+//  legacysuffixes -- the map lookup DomainSuffixes used before the trie, for comparison
+//
+type legacysuffixes struct {
+	reversedsuffixes map[string]bool // suffixes, reversed. Set.
+}
+
+//
+//  newlegacysuffixes -- build legacy map from the normal rules of a loaded trie
+//
+func newlegacysuffixes(d *DomainSuffixes) legacysuffixes {
+	l := legacysuffixes{reversedsuffixes: make(map[string]bool)}
+	var walk func(n *suffixnode, suffix string)
+	walk = func(n *suffixnode, suffix string) {
+		if n.flags&rulenormal != 0 {
+			l.reversedsuffixes[Reversedomain(suffix)] = true
+		}
+		for label, child := range n.children {
+			if suffix == "" {
+				walk(child, label)
+			} else {
+				walk(child, label+"."+suffix)
+			}
+		}
+	}
+	walk(d.root, "")
+	return l
+}
+
+//
+//  Domainparts -- the old lookup, one Join, Reversedomain and map probe per label
+//
+func (d *legacysuffixes) Domainparts(s string) (string, string, string, bool) {
+	parts := strings.Split(s, ".")    // domain parts
+	for i := 0; i < len(parts); i++ { // finding longest TLD that matches
+		tld := strings.Join(parts[i:], ".")         // candidate TLD
+		if d.reversedsuffixes[Reversedomain(tld)] { // if matched TLD
+			switch {
+			case i == 0:
+				return "", "", tld, false // this is a TLD - it has no 2nd
+			case i == 1:
+				return "", parts[0], tld, true // second level domain
+			default:
+				return strings.Join(parts[0:i-1], "."), parts[i-1], tld, true // sub, 2nd, tld, OK
+			}
+		}
+	}
+	return "", "", "", false // no TLD match
+}
+
+//
+//  Typical names from certificates, for benchmarks.
+//
+var benchnames = []string{
+	"www.example.com",
+	"mail.google.com",
+	"secure.login.bank.co.uk",
+	"a.b.c.d.example.org",
+	"www.test.ac.jp",
+	"shop.example.com.br",
+	"localhost",
+	"cdn-123.edge.example.net",
+}
+
+//
+//  TestSuffixoffsets -- offsets agree with Domainparts, with no allocation
+//
+func TestSuffixoffsets(t *testing.T) {
+	var d DomainSuffixes
+	err := d.Loadpublicsuffixlist(testsuffixfile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "sub.example.co.uk"
+	offs := d.Suffixoffsets(name)
+	if offs.Registrable != 4 || offs.Suffix != 12 || offs.Section != Sectionicann {
+		t.Errorf("Suffixoffsets(%q) = %+v", name, offs)
+	}
+	legacy := newlegacysuffixes(&d)
+	for _, name := range benchnames { // same answers as before for normal names
+		sub, second, tld, ok := d.Domainparts(name)
+		lsub, lsecond, ltld, lok := legacy.Domainparts(name)
+		if sub != lsub || second != lsecond || tld != ltld || ok != lok {
+			t.Errorf("Domainparts(%q) = %q %q %q %t, legacy %q %q %q %t", name,
+				sub, second, tld, ok, lsub, lsecond, ltld, lok)
+		}
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, name := range benchnames {
+			d.Domainparts(name)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Domainparts allocates: %f allocations per run", allocs)
+	}
+}
+
+//
+//  BenchmarkDomainparts -- trie lookup
+//
+func BenchmarkDomainparts(b *testing.B) {
+	var d DomainSuffixes
+	err := d.Loadpublicsuffixlist(testsuffixfile, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Domainparts(benchnames[i%len(benchnames)])
+	}
+}
+
+//
+//  BenchmarkDomainpartslegacy -- map lookup it replaced
+//
+func BenchmarkDomainpartslegacy(b *testing.B) {
+	var d DomainSuffixes
+	err := d.Loadpublicsuffixlist(testsuffixfile, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	legacy := newlegacysuffixes(&d)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		legacy.Domainparts(benchnames[i%len(benchnames)])
+	}
+}
+
+//
+//  TestNormalizeHostname -- canonical names and classes
+//
+func TestNormalizeHostname(t *testing.T) {
+	tests := []struct {
+		in    string
+		out   string
+		class Hostclass
+	}{
+		{"www.example.com", "www.example.com", Hostname},
+		{" WWW.Example.COM. ", "www.example.com", Hostname},
+		{"*.Example.com", "example.com", Wildcard},
+		{"xn--85x722f.com.cn", "食狮.com.cn", Hostname},
+		{"ＥＸＡＭＰＬＥ.com", "example.com", Hostname},
+		{"192.168.1.1", "192.168.1.1", IPliteral},
+		{"[2001:DB8::1]", "2001:db8::1", IPliteral},
+		{"John Smith", "John Smith", Nothostname},
+		{"", "", Nothostname},
+	}
+	for _, test := range tests {
+		out, class, err := NormalizeHostname(test.in)
+		if err != nil || out != test.out || class != test.class {
+			t.Errorf("NormalizeHostname(%q) = %q, %s, %v; expected %q, %s", test.in, out, class, err, test.out, test.class)
+		}
+	}
+	if _, _, err := NormalizeHostname("xn--55555577.com"); err == nil {
+		t.Errorf("NormalizeHostname accepted bad punycode")
+	}
+}
+
+//
+//  TestValidatehostname -- classification of CN and SAN values
+//
+func TestValidatehostname(t *testing.T) {
+	tests := []struct {
+		in     string
+		status Hoststatus
+	}{
+		{"www.example.com", Hostok},
+		{"*.example.com", Hostok},
+		{"食狮.com.cn", Hostok},
+		{"_dmarc.example.com", Hostunderscore},
+		{"10.1.2.3", Hostipliteral},
+		{"John Smith", Hostnothostname},
+		{"exchange.corp", Hostinternal},
+		{"printer.local", Hostinternal},
+		{"a..example.com", Hostemptylabel},
+		{"-www.example.com", Hosthyphen},
+		{strings.Repeat("a", 64) + ".com", Hostlabeltoolong},
+		{strings.Repeat("abcdefg.", 32) + "com", Hosttoolong},
+	}
+	for _, test := range tests {
+		name, class, _ := NormalizeHostname(test.in)
+		status := Validatehostname(name, class)
+		if status != test.status {
+			t.Errorf("Validatehostname(%q) = %s, expected %s", test.in, status, test.status)
+		}
+	}
+	if Checkhostsyntax("exa$mple.com") != Hostbadchar {
+		t.Errorf("Checkhostsyntax accepted bad character")
+	}
+}
+
+//
+//  TestHomographs -- skeletons and lookalike detection
+//
+func TestHomographs(t *testing.T) {
+	var conf Confusables
+	err := conf.Loadconfusables("../data/confusables.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Skeleton("раураl.com") != conf.Skeleton("paypal.com") { // Cyrillic lookalike
+		t.Errorf("Skeletons differ: %q %q", conf.Skeleton("раураl.com"), conf.Skeleton("paypal.com"))
+	}
+	//  Pairs with the same skeleton per TR39: Greek, Armenian, digit,
+	//  and multi-character prototypes.
+	tr39 := [][2]string{
+		{"αmazon.com", "amazon.com"},        // GREEK SMALL LETTER ALPHA
+		{"gοοgle.com", "google.com"},        // GREEK SMALL LETTER OMICRON
+		{"gօօgle.com", "google.com"},        // ARMENIAN SMALL LETTER OH
+		{"ѕсоре.com", "scope.com"},          // Cyrillic
+		{"examp1e.com", "example.com"},      // DIGIT ONE
+		{"g00gle.com", "google.com"},        // DIGIT ZERO, prototype "O"
+		{"rnicrosoft.com", "microsoft.com"}, // "m" has prototype "rn"
+		{"ǉeto.com", "ljeto.com"},           // LATIN SMALL LETTER LJ
+	}
+	for _, pair := range tr39 {
+		if conf.Skeleton(pair[0]) != conf.Skeleton(pair[1]) {
+			t.Errorf("Skeletons differ: %q -> %q, %q -> %q", pair[0], conf.Skeleton(pair[0]), pair[1], conf.Skeleton(pair[1]))
+		}
+	}
+	if conf.Skeleton("example.com") == conf.Skeleton("exemple.com") {
+		t.Errorf("Different names have same skeleton")
+	}
+	if !Mixedscript("раураl.com") || Mixedscript("paypal.com") || Mixedscript("пример.рф") {
+		t.Errorf("Mixedscript wrong")
+	}
+	var h Homographs
+	h.Init(&conf, &Brandlist{Brands: []Brand{{Domain: "paypal.com", Name: "paypal"}}})
+	if _, found := h.Check("paypal.com"); found {
+		t.Errorf("Brand flagged as its own lookalike")
+	}
+	match, found := h.Check("раураl.com")
+	if !found || !match.Brand || match.Lookalike != "paypal.com" || !match.Mixedscript {
+		t.Errorf("Brand lookalike not found: %+v", match)
+	}
+	if _, found = h.Check("example.com"); found {
+		t.Errorf("First sighting flagged")
+	}
+	if match, found = h.Check("examp1e.com"); found { // both ASCII, and not a brand
+		t.Errorf("ASCII pair flagged: %+v", match)
+	}
+	match, found = h.Check("ехаmple.com") // Cyrillic "е" and "х"
+	if !found || match.Brand || match.Lookalike != "example.com" {
+		t.Errorf("Dataset IDN lookalike not found: %+v", match)
+	}
+	match, found = h.Check("paypa1.com") // ASCII, but a brand
+	if !found || !match.Brand || match.Lookalike != "paypal.com" {
+		t.Errorf("ASCII brand lookalike not found: %+v", match)
+	}
+	//  Once full, new skeletons are not kept, but still checked.
+	h.Init(&conf, nil)
+	h.maxseen = 1
+	h.Check("example.com")
+	h.Check("google.com")
+	if len(h.seen) != 1 {
+		t.Errorf("seen grew past maxseen: %d", len(h.seen))
+	}
+	if _, found = h.Check("ехаmple.com"); !found {
+		t.Errorf("Lookalike of kept 2LD not found when full")
+	}
+}
+
+//
+//  TestTyposquats -- each rule, and brands and their owners' domains not flagged
+//
+func TestTyposquats(t *testing.T) {
+	var conf Confusables
+	err := conf.Loadconfusables("../data/confusables.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d DomainSuffixes
+	if err := d.Loadpublicsuffixlist(testsuffixfile, false); err != nil {
+		t.Fatal(err)
+	}
+	brandfile := filepath.Join(t.TempDir(), "brands.txt")
+	brandlist := "# brands\npaypal.com paypalobjects.com # and its CDN\namazon.com amazonaws.com\namazonsupport.com\n"
+	if err := os.WriteFile(brandfile, []byte(brandlist), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var brands Brandlist
+	if err := brands.Loadbrands(brandfile, &d); err != nil {
+		t.Fatal(err)
+	}
+	if len(brands.Brands) != 3 || len(brands.Brands[0].Owned) != 1 || brands.Brands[1].Owned[0] != "amazonaws.com" {
+		t.Fatalf("Loadbrands: got %+v", brands.Brands)
+	}
+	var ts Typosquats
+	ts.Init(&brands, &conf)
+	tests := []struct {
+		domain string
+		found  bool
+		rule   Typosquatrule
+	}{
+		{"paypal.com", false, 0},
+		{"paypal.de", false, 0}, // other TLDs of brand not flagged
+		{"example.com", false, 0},
+		{"paypa1.com", true, Typohomoglyph},
+		{"paypcl.com", true, Typobitsquat}, // 'a' ^ 'c' is one bit
+		{"paypak.com", true, Typokeyboard},
+		{"paypakl.net", true, Typokeyboard}, // extra key next to right one
+		{"papyal.com", true, Typoeditdistance},
+		{"paypall.com", true, Typoeditdistance},
+		{"amazon-secure-login.net", true, Typoembedded},
+		{"paypalobjects.com", false, 0},           // owned by the brand
+		{"amazonaws.com", false, 0},               // owned by the brand
+		{"amazonsupport.com", false, 0},           // in the brand file itself
+		{"paypalobjects.net", true, Typoembedded}, // not listed, so still suspect
+	}
+	for _, test := range tests {
+		match, found := ts.Check(test.domain)
+		if found != test.found || (found && match.Rule != test.rule) {
+			t.Errorf("%s: got %v %s, expected %v %s", test.domain, found, match.Rule, test.found, test.rule)
+		}
+	}
+	if Editdistance("paypal", "pyapal") != 1 || Editdistance("", "abc") != 3 || Editdistance("kitten", "sitting") != 3 {
+		t.Errorf("Editdistance wrong")
+	}
+	var none Typosquats // no brand list
+	none.Init(nil, &conf)
+	if match, found := none.Check("paypa1.com"); found {
+		t.Errorf("No brands: got %+v", match)
+	}
+	var rows []int // work space reused across calls of different lengths
+	if editdistance("kitten", "sitting", &rows) != 3 || editdistance("ca", "abc", &rows) != 3 || editdistance("abc", "", &rows) != 3 {
+		t.Errorf("editdistance with reused rows wrong")
+	}
+}
+
+//
+//  TestRelatedness -- one owner, strangers, and intermediaries
+//
+func TestRelatedness(t *testing.T) {
+	var m Intermediaries
+	err := m.Loadintermediaries("../data/ovblacklist.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Isintermediary("cloudflare.com"); !ok {
+		t.Errorf("cloudflare.com not an intermediary")
+	}
+	if _, ok := m.Isintermediary("sonymusic.com"); ok {
+		t.Errorf("sonymusic.com is an intermediary") // "F" row
+	}
+	var r Relatednessscorer
+	r.Init(&Brandlist{Brands: []Brand{{Domain: "paypal.com", Name: "paypal"}}}, &m)
+	tests := []struct {
+		domains []string
+		org     string
+		score   float64
+	}{
+		{[]string{"example.com"}, "", 1},
+		{[]string{"example.com", "example.co.uk", "example.de"}, "", 1},
+		{[]string{"sonymusic.com", "sony.co.jp", "rockband.com"}, "Sony Music Entertainment", 2.0 / 3},
+		{[]string{"paypal.com", "paypalobjects.com", "strangers.net", "others.org"}, "", 0.5},
+		{[]string{"alpha.com", "bravo.net", "charlie.org", "delta.info"}, "Acme, Inc.", 0},
+		{[]string{"cloudflare.com"}, "CloudFlare, Inc.", 1},                     // the intermediary's own cert
+		{[]string{"cloudflare.com", "cloudflare.net"}, "CloudFlare, Inc.", 1},   // one owner, even if an intermediary
+		{[]string{"cloudflare.com", "cloudflaressl.com", "example.com"}, "", 0}, // CDN customer cert
+	}
+	for _, test := range tests {
+		rel := r.Score(test.domains, test.org)
+		if rel.Score < test.score-0.001 || rel.Score > test.score+0.001 {
+			t.Errorf("Score(%v, %q) = %+v, expected %1.3f", test.domains, test.org, rel, test.score)
+		}
+	}
+}
+
+//
+//  TestOidtable -- CSV conversion, problems reported, and both formats load the same
+//
+func TestOidtable(t *testing.T) {
+	const csvfile = "../data/catypetable.csv"
+	entries, problems, err := Readoidcsv(csvfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := false // OID broken across lines in spreadsheet
+	for _, p := range problems {
+		broken = broken || strings.Contains(p.Reason, "22234.2.")
+	}
+	if len(entries) == 0 || !broken {
+		t.Errorf("Readoidcsv: %d entries, broken OID not reported in %v", len(entries), problems)
+	}
+	jsonfile := filepath.Join(t.TempDir(), "catypetable.json")
+	err = Writeoidtable(jsonfile, "test", entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromcsv, fromjson CApolicyinfo
+	_, err = fromcsv.Loadoidinfo(csvfile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fromjson.Loadoidinfo(jsonfile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, aok := fromcsv.Getpolicy("2.16.840.1.114412.2")
+	b, bok := fromjson.Getpolicy("2.16.840.1.114412.2")
+	if !aok || !bok || a != b || a.Policy != "EV" || a.CPS == "" || a.Notes == "" {
+		t.Errorf("Loaded policies differ: %+v %+v", a, b)
+	}
+	if _, ok := fromcsv.Getpolicy("5.2.3.1"); ok {
+		t.Errorf("Broken OID fragment loaded")
+	}
+}
+
+//
+//  TestOidconflicts -- duplicates and conflicts found, strict mode fails on conflicts only
+//
+func TestOidconflicts(t *testing.T) {
+	entries := []Policyentry{
+		{CAname: "Alpha CA", Level: "EV", OID: "1.2.3.1", Source: "row 1"},
+		{CAname: "Alpha CA", Level: "OV", OID: "1.2.3.2", Source: "row 1"},
+		{CAname: "Alpha CA", Level: "EV", OID: "1.2.3.1", Source: "row 2"},
+		{CAname: "Beta CA", Level: "DV", OID: "1.2.3.2", Source: "row 3"},
+	}
+	conflicts := Findoidconflicts(entries)
+	if len(conflicts) != 2 || conflicts[0].OID != "1.2.3.1" || conflicts[0].Conflict ||
+		conflicts[1].OID != "1.2.3.2" || !conflicts[1].Conflict || len(conflicts[1].Sources) != 2 {
+		t.Fatalf("Findoidconflicts: got %v", conflicts)
+	}
+	infile := filepath.Join(t.TempDir(), "conflicts.json")
+	err := Writeoidtable(infile, "test", entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c CApolicyinfo
+	if _, err = c.Loadoidinfo(infile, true); err == nil {
+		t.Errorf("Strict load accepted conflicting OIDs")
+	}
+	found, err := c.Loadoidinfo(infile, false)
+	if err != nil || len(found) != 2 {
+		t.Errorf("Warning load: %v, %v", found, err)
+	}
+	if p, _ := c.Getpolicy("1.2.3.2"); p.CAname != "Beta CA" {
+		t.Errorf("Last listing did not win: %+v", p)
+	}
+}
+
+//
+//  TestOidconflictscabforum -- CAs listing a reserved OID conflict only at another level
+//
+func TestOidconflictscabforum(t *testing.T) {
+	entries := []Policyentry{
+		{CAname: "Alpha CA", Level: "DV", OID: "2.23.140.1.2.1", Source: "row 1"},
+		{CAname: "Beta CA", Level: "DV", OID: "2.23.140.1.2.1", Source: "row 2"},
+		{CAname: "Beta CA", Level: "EV", OID: "2.23.140.1.2.2", Source: "row 2"},
+	}
+	conflicts := Findoidconflicts(entries)
+	if len(conflicts) != 1 || conflicts[0].OID != "2.23.140.1.2.2" || !conflicts[0].Conflict ||
+		conflicts[0].CAnames[0] != Cabforumname || conflicts[0].Levels[0] != "OV" {
+		t.Fatalf("Findoidconflicts: got %v", conflicts)
+	}
+}
+
+//
+//  TestOidtablelint -- the shipped OID tables load strictly, with no conflicts
+//
+func TestOidtablelint(t *testing.T) {
+	for _, infile := range []string{"../data/catypetable.csv", "../data/catypetable.json"} {
+		entries, _, err := Readoidfile(infile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, conflict := range Findoidconflicts(entries) {
+			t.Errorf("%s: %s", infile, conflict)
+		}
+		var c CApolicyinfo
+		if _, err = c.Loadoidinfo(infile, true); err != nil {
+			t.Errorf("%s: strict load: %v", infile, err)
+			continue
+		}
+		for _, oid := range []string{"2.23.140.1.2.1", "2.23.140.1.2.2"} {
+			if p, _ := c.Getpolicy(oid); p.CAname != Cabforumname {
+				t.Errorf("%s: %s attributed to '%s'", infile, oid, p.CAname)
+			}
+		}
+	}
+}
+
+//
+//  TestCabforum -- reserved OIDs known without the table, and the table wins
+//
+func TestCabforum(t *testing.T) {
+	entries := []Policyentry{
+		{CAname: "Alpha CA", Level: "EV", OID: "1.2.3.1"},
+		{CAname: "Alpha CA", Level: "OV", OID: "2.23.140.1.2.2", CPS: "https://alpha.example/cps"},
+	}
+	infile := filepath.Join(t.TempDir(), "cabforum.json")
+	err := Writeoidtable(infile, "test", entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c CApolicyinfo
+	if _, err = c.Loadoidinfo(infile, true); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		oid     string
+		level   string
+		purpose string
+		caname  string
+	}{
+		{"2.23.140.1.2.3", "IV", PurposeTLS, Cabforumname},
+		{"2.23.140.1.2.2", "OV", PurposeTLS, Cabforumname}, // registry wins over the table
+		{"2.23.140.1.5.4.2", "IV", PurposeSMIME, Cabforumname},
+		{"2.23.140.1.4.2", "", PurposeTimestamping, Cabforumname},
+		{"1.2.3.1", "EV", PurposeTLS, "Alpha CA"},
+	}
+	for _, test := range tests {
+		p, ok := c.Getpolicy(test.oid)
+		if !ok || p.Policy != test.level || p.Purpose != test.purpose || p.CAname != test.caname {
+			t.Errorf("Getpolicy(%s): got %+v, %v", test.oid, p, ok)
+		}
+	}
+	if p, ok := c.Getpolicy("2.23.140.1.31"); ok { // Tor onion service extension, not a policy
+		t.Errorf("Getpolicy(2.23.140.1.31): got %+v", p)
+	}
+	if !Ispolicylevel("IV") || Ispolicylevel("") {
+		t.Errorf("Ispolicylevel wrong for IV or empty")
+	}
+}
+
+//
+//  TestEnterprisearc -- enterprise arc of an OID, and owner of an arc from the OID table
+//
+func TestEnterprisearc(t *testing.T) {
+	if arc := Enterprisearc("1.3.6.1.4.1.6449.1.2.1.5.1"); arc != "1.3.6.1.4.1.6449" {
+		t.Errorf("Enterprisearc: got '%s'", arc)
+	}
+	if arc := Enterprisearc("2.23.140.1.2.1"); arc != "" {
+		t.Errorf("Enterprisearc of CA/B Forum OID: got '%s'", arc)
+	}
+	entries := []Policyentry{
+		{CAname: "Alpha CA", Level: "EV", OID: "1.3.6.1.4.1.99999.1.1"},
+		{CAname: "Alpha CA", Level: "OV", OID: "1.3.6.1.4.1.99999.1.2"},
+		{CAname: "Beta CA", Level: "DV", OID: "1.3.6.1.4.1.99999.2.1"},
+	}
+	dir := t.TempDir()
+	infile := filepath.Join(dir, "arcs.json")
+	if err := Writeoidtable(infile, "test", entries); err != nil {
+		t.Fatal(err)
+	}
+	var c CApolicyinfo
+	if _, err := c.Loadoidinfo(infile, true); err != nil {
+		t.Fatal(err)
+	}
+	if owner, ok := c.Arcowner("1.3.6.1.4.1.99999.7"); !ok || owner != "Alpha CA" {
+		t.Errorf("Arcowner: got '%s', %v", owner, ok)
+	}
+	if _, ok := c.Arcowner("1.3.6.1.4.1.88888.1"); ok {
+		t.Errorf("Arcowner found owner of unknown arc")
+	}
+	//  Unknown OIDs are written as CSV OID table rows, which read back.
+	csvfile := filepath.Join(dir, "unknown.csv")
+	unknown := []Policyentry{{CAname: "Gamma CA", Level: "IV", OID: "1.3.6.1.4.1.77777.1", Notes: "UNKNOWN OID"}}
+	if err := Writeoidcsv(csvfile, "test", unknown); err != nil {
+		t.Fatal(err)
+	}
+	back, problems, err := Readoidcsv(csvfile)
+	if err != nil || len(problems) != 0 || len(back) != 1 || back[0].OID != unknown[0].OID ||
+		back[0].Level != "OV" || back[0].CAname != "Gamma CA" || back[0].Notes != "UNKNOWN OID" {
+		t.Errorf("Writeoidcsv round trip: got %+v, %v, %v", back, problems, err)
+	}
+	if err := Writeoidcsv(csvfile, "test", []Policyentry{{CAname: "X", OID: "2.23.140.2.1"}}); err == nil {
+		t.Errorf("Writeoidcsv accepted entry with no level")
+	}
+}
+
+//
+//  testpolicycert -- a Policycert for tests
+//
+type testpolicycert struct {
+	oids   []string
+	issuer string
+	cps    []string // CPS URIs, same for every OID
+}
+
+func (c testpolicycert) Policyoids() []string          { return c.oids }
+func (c testpolicycert) Issuerorg() string             { return c.issuer }
+func (c testpolicycert) Policycps(oid string) []string { return c.cps }
+
+//
+//  TestClassify -- deciding OIDs, levels, and anomalies
+//
+func TestClassify(t *testing.T) {
+	entries := []Policyentry{
+		{CAname: "Alpha CA Ltd", Level: "EV", OID: "1.3.6.1.4.1.99999.1.1", CPS: "https://www.alpha.example/legal/cps"},
+		{CAname: "Alpha CA Ltd", Level: "OV", OID: "1.3.6.1.4.1.99999.1.2"},
+		{CAname: "Beta Trust", Level: "DV", OID: "1.3.6.1.4.1.88888.1"},
+		{CAname: "Network Solutions", Level: "OV", OID: "1.3.6.1.4.1.77777.1"},
+	}
+	infile := filepath.Join(t.TempDir(), "classify.json")
+	if err := Writeoidtable(infile, "test", entries); err != nil {
+		t.Fatal(err)
+	}
+	var c CApolicyinfo
+	if _, err := c.Loadoidinfo(infile, true); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		cert      testpolicycert
+		level     string
+		oids      int
+		anomalies int
+	}{
+		{testpolicycert{[]string{"1.3.6.1.4.1.99999.1.1", "2.23.140.1.1"}, "ALPHA CA Limited", nil}, "EV", 1, 0},
+		{testpolicycert{[]string{"2.23.140.1.2.2"}, "Gamma", nil}, "OV", 1, 0},
+		{testpolicycert{[]string{"1.3.6.1.4.1.99999.1.1", "2.23.140.1.2.1"}, "Alpha CA", nil}, "EV", 1, 1},
+		{testpolicycert{[]string{"1.3.6.1.4.1.99999.1.1", "1.3.6.1.4.1.99999.1.2"}, "Alpha CA", nil}, "OV", 2, 1},
+		{testpolicycert{[]string{"1.3.6.1.4.1.88888.1"}, "Alpha CA", nil}, "DV", 1, 1},
+		{testpolicycert{[]string{"2.23.140.1.5.1.2"}, "Alpha CA", nil}, "", 0, 1},
+		{testpolicycert{[]string{"1.2.3.4"}, "Alpha CA", nil}, "", 0, 0},
+		{testpolicycert{[]string{"1.3.6.1.4.1.77777.1"}, "Network Solutions L.L.C.", nil}, "OV", 1, 0},
+		{testpolicycert{[]string{"1.3.6.1.4.1.77777.1"}, "The USERTRUST Network", nil}, "OV", 1, 1},
+		{testpolicycert{[]string{"1.3.6.1.4.1.99999.1.1"}, "Alpha CA", []string{"http://repository.alpha.example/cps"}}, "EV", 1, 0},
+		{testpolicycert{[]string{"1.3.6.1.4.1.99999.1.1"}, "Alpha CA", []string{"https://cps.phish.example/"}}, "EV", 1, 1},
+	}
+	for _, test := range tests {
+		got := c.Classify(test.cert)
+		if got.Level != test.level || len(got.OIDs) != test.oids || len(got.Anomalies) != test.anomalies {
+			t.Errorf("Classify(%v): got %+v", test.cert, got)
+		}
+	}
+}
+
+//
+//  TestCpsmatches -- same host or a subdomain of it, never a parent
+//
+func TestCpsmatches(t *testing.T) {
+	tests := []struct {
+		tablecps string
+		certcps  []string
+		match    bool
+	}{
+		{"https://www.digicert.com/legal-repository", []string{"http://repository.digicert.com/cps"}, true},
+		{"https://www.alpha.example/cps", []string{"https://alpha.example/legal"}, true},
+		{"https://cps.alpha.example/", []string{"https://other.example/", "http://cps.alpha.example/v2"}, true},
+		{"https://cps.alpha.example/", []string{"https://alpha.example/cps"}, false}, // parent of table's host
+		{"https://www.alpha.co.uk/cps", []string{"https://co.uk/"}, false},           // bare public suffix
+		{"https://www.alpha.example/cps", []string{"https://phish.example/"}, false},
+		{"", []string{"https://anything.example/"}, true}, // nothing to check against
+	}
+	for _, test := range tests {
+		if got := cpsmatches(test.tablecps, test.certcps); got != test.match {
+			t.Errorf("cpsmatches(%q, %v) = %v, expected %v", test.tablecps, test.certcps, got, test.match)
+		}
+	}
+}
+
+//
+//  TestIssuerhasca -- whole words of the CA name, not generic CA words
+//
+func TestIssuerhasca(t *testing.T) {
+	tests := []struct {
+		issuer string
+		caname string
+		match  bool
+	}{
+		{"COMODO CA Limited", "Comodo CA Ltd", true},
+		{"GoDaddy.com, Inc.", "Go Daddy", true},
+		{"DigiCert Inc", "DigiCert", true},
+		{"The USERTRUST Network", "Network Solutions", false},
+		{"USERTrust", "Trust", false},
+		{"Network Solutions L.L.C.", "Network Solutions", true},
+		{"Entrust, Inc.", "Trustwave", false},
+		{"Secure Digital Trust Network", "Global Secure Trust", false},
+	}
+	for _, test := range tests {
+		if got := issuerhasca(test.issuer, test.caname); got != test.match {
+			t.Errorf("issuerhasca(%q, %q) = %v, expected %v", test.issuer, test.caname, got, test.match)
+		}
+	}
+}
+
+//
+//  TestEnterprisenumbers -- registry parsing, and owners of unknown OIDs named from it
+//
+func TestEnterprisenumbers(t *testing.T) {
+	const registry = `PRIVATE ENTERPRISE NUMBERS
+
+Prefix: iso.org.dod.internet.private.enterprise (1.3.6.1.4.1)
+
+Decimal
+| Organization
+| | Contact
+| | | Email
+| | | |
+0
+  Reserved
+    Internet Assigned Numbers Authority
+      iana&iana.org
+6449
+  Sectigo Limited
+    Contact Name
+      contact&example.com
+99999
+  ---none---
+`
+	dir := t.TempDir()
+	penfile := filepath.Join(dir, "enterprise-numbers.txt")
+	if err := os.WriteFile(penfile, []byte(registry), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var pens Enterprisenumbers
+	if err := pens.Loadenterprisenumbers(penfile); err != nil {
+		t.Fatal(err)
+	}
+	if pens.Count() != 2 {
+		t.Errorf("Loadenterprisenumbers: got %d numbers, expected 2", pens.Count())
+	}
+	if org, ok := pens.Organization("1.3.6.1.4.1.6449.1.2.1.5.1"); !ok || org != "Sectigo Limited" {
+		t.Errorf("Organization: got '%s', %v", org, ok)
+	}
+	if _, ok := pens.Organization("1.3.6.1.4.1.99999.1"); ok {
+		t.Errorf("Organization found for unassigned number")
+	}
+	//  Unknown OIDs get a name for capolicies, but no level.
+	infile := filepath.Join(dir, "pens.json")
+	if err := Writeoidtable(infile, "test", []Policyentry{{CAname: "Alpha CA", Level: "EV", OID: "1.2.3.1"}}); err != nil {
+		t.Fatal(err)
+	}
+	var c CApolicyinfo
+	if _, err := c.Loadoidinfo(infile, true); err != nil {
+		t.Fatal(err)
+	}
+	c.Setenterprisenumbers(&pens)
+	if org, ok := c.Oidorganization("1.3.6.1.4.1.6449.1.2.1.5.1"); !ok || org != "Sectigo Limited" {
+		t.Errorf("Oidorganization: got '%s', %v", org, ok)
+	}
+	if n := c.Addenterpriseoids([]string{"1.2.3.1", "1.3.6.1.4.1.6449.1.2.1.5.1", "1.3.6.1.4.1.12345.1"}); n != 1 {
+		t.Errorf("Addenterpriseoids: added %d, expected 1", n)
+	}
+	if _, ok := c.Getpolicy("1.3.6.1.4.1.6449.1.2.1.5.1"); ok {
+		t.Errorf("Getpolicy returned OID named only from registry")
+	}
+}
+
+//
+//  TestMergepolicies -- OIDs only in the table, only in capolicies, and differing
+//
+func TestMergepolicies(t *testing.T) {
+	table := map[string]Policyinfo{
+		"1.2.3.1": {CAname: "Alpha CA", Policy: "EV", Purpose: PurposeTLS},
+		"1.2.3.2": {CAname: "Alpha CA", Policy: "OV", Purpose: PurposeTLS},
+		"1.2.3.3": {CAname: "Alpha CA", Policy: "DV", Purpose: PurposeTLS},
+	}
+	dbentries := []Policyentry{
+		{CAname: "Alpha CA", Level: "EV", OID: "1.2.3.1", Purpose: PurposeTLS},
+		{CAname: "Alpha CA", Level: "IV", OID: "1.2.3.2", Purpose: PurposeTLS},
+		{CAname: "Beta CA", Level: "DV", OID: "1.2.3.4", Purpose: PurposeTLS},
+	}
+	differences := mergepolicies(table, dbentries)
+	if len(differences) != 3 {
+		t.Fatalf("mergepolicies: got %v", differences)
+	}
+	if d := differences[0]; d.OID != "1.2.3.2" || d.Table == "" || d.DB == "" {
+		t.Errorf("changed OID: got %v", d)
+	}
+	if d := differences[1]; d.OID != "1.2.3.3" || d.DB != "" {
+		t.Errorf("OID only in table: got %v", d)
+	}
+	if d := differences[2]; d.OID != "1.2.3.4" || d.Table != "" {
+		t.Errorf("OID only in database: got %v", d)
+	}
+}
+
+//
+//  TestMergeedited -- in a merge, capolicies rows win only if edited since loaded
+//
+func TestMergeedited(t *testing.T) {
+	entries := []Policyentry{
+		{CAname: "Alpha CA", Level: "OV", OID: "1.2.3.1"},
+		{CAname: "Alpha CA", Level: "OV", OID: "1.2.3.2"},
+	}
+	infile := filepath.Join(t.TempDir(), "merge.json")
+	err := Writeoidtable(infile, "test", entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c CApolicyinfo
+	if _, err = c.Loadoidinfo(infile, true); err != nil {
+		t.Fatal(err)
+	}
+	dbentries := []Policyentry{
+		{CAname: "Alpha CA", Level: "DV", OID: "1.2.3.1", Purpose: PurposeTLS},               // written by an earlier load
+		{CAname: "Alpha CA", Level: "EV", OID: "1.2.3.2", Purpose: PurposeTLS, Edited: true}, // fixed by an analyst
+		{CAname: "Beta CA", Level: "DV", OID: "1.2.3.3", Purpose: PurposeTLS},                // dropped from the table
+		{CAname: "Gamma CA", Level: "OV", OID: "1.2.3.4", Purpose: PurposeTLS, Edited: true}, // added by an analyst
+	}
+	differences := c.Mergeoiddb(dbentries)
+	if len(differences) < 4 || differences[0].Edited || !differences[1].Edited || differences[2].Edited { // then the registry OIDs
+		t.Errorf("Mergeoiddb: got %v", differences)
+	}
+	tests := []struct {
+		oid    string
+		level  string
+		caname string
+	}{
+		{"1.2.3.1", "OV", "Alpha CA"}, // file wins
+		{"1.2.3.2", "EV", "Alpha CA"}, // edit wins
+		{"1.2.3.3", "", ""},           // not loaded
+		{"1.2.3.4", "OV", "Gamma CA"},
+	}
+	for _, test := range tests {
+		p, _ := c.Getpolicy(test.oid)
+		if p.Policy != test.level || p.CAname != test.caname {
+			t.Errorf("Getpolicy(%s): got %+v", test.oid, p)
+		}
+	}
+	if p, _ := c.Getpolicy("1.2.3.2"); !p.Edited {
+		t.Errorf("Edit not kept as an edit: %+v", p)
+	}
+}